@@ -37,13 +37,25 @@ var (
 
 	symbolLine  = regexp.MustCompile(`^\w+\s+<\w+>:$`)
 	dataLine    = regexp.MustCompile(`^\w+:\s+\w+\s+.+$`)
-	leaqRIPLine = regexp.MustCompile(`^leaq\s+([A-Za-z_][A-Za-z0-9_]*)\(%rip\), %([a-z0-9]+)$`)
+	leaqRIPLine = regexp.MustCompile(`^leaq\s+([A-Za-z_.$][\w.$]*)\(%rip\), %([a-z0-9]+)$`)
+	gotLine     = regexp.MustCompile(`^movq\s+([A-Za-z_.$][\w.$]*)@GOTPCREL\(%rip\), %([a-z0-9]+)$`)
+	ripOperand  = regexp.MustCompile(`^([A-Za-z_.$][\w.$]*)([+-]\d+)?\(%rip\)$`)
+	callLine    = regexp.MustCompile(`^callq?\s+([A-Za-z_][A-Za-z0-9_]*)(?:@PLT)?$`)
+	jumpLine    = regexp.MustCompile(`^jmpq?\s+\*%([a-z0-9]+)$`)
+	baseLine    = regexp.MustCompile(`^addq\s+%([a-z0-9]+), %([a-z0-9]+)$`)
+	tableLine   = regexp.MustCompile(`\.(LJTI\w+)\(%rip\)`)
+	tailLine    = regexp.MustCompile(`^(j[a-z]+)\s+([A-Za-z_][A-Za-z0-9_]*)(?:@PLT)?(?:\s+#.*)?$`)
 
 	registers    = []string{"DI", "SI", "DX", "CX", "R8", "R9"}
 	xmmRegisters = []string{"X0", "X1", "X2", "X3", "X4", "X5", "X6", "X7"}
 	dataSymbols  []internal.DataSymbol
 )
 
+var (
+	callTarget     = internal.MatchCallee(callLine)
+	tailCallTarget = internal.MatchCallee(tailLine)
+)
+
 func amd64Register(reg string) string {
 	switch strings.TrimPrefix(reg, "%") {
 	case "rax", "eax", "ax", "al":
@@ -83,11 +95,142 @@ func amd64Register(reg string) string {
 	}
 }
 
+// relocated reports whether a relocation of an instruction is resolved by its
+// rewrite into Go assembly.
+func relocated(lines []internal.Line, index int, relocation internal.Relocation) bool {
+	asm := lines[index].Assembly
+	switch relocation.Type {
+	case "R_X86_64_PC32", "R_X86_64_PLT32":
+		_, isCall := callTarget(asm)
+		_, _, isTailCall := tailCall(asm)
+		return isCall || isTailCall || strings.Contains(asm, "(%rip)")
+	case "R_X86_64_GOTPCREL", "R_X86_64_GOTPCRELX", "R_X86_64_REX_GOTPCRELX":
+		return gotLine.MatchString(asm)
+	}
+	return false
+}
+
+// ripMnemonics maps AT&T mnemonics to Go assembler mnemonics where they differ
+// by more than case.
+var ripMnemonics = map[string]string{
+	"movzbw":    "MOVBWZX",
+	"movzbl":    "MOVBLZX",
+	"movzbq":    "MOVBQZX",
+	"movzwl":    "MOVWLZX",
+	"movzwq":    "MOVWQZX",
+	"movsbw":    "MOVBWSX",
+	"movsbl":    "MOVBLSX",
+	"movsbq":    "MOVBQSX",
+	"movswl":    "MOVWLSX",
+	"movswq":    "MOVWQSX",
+	"movslq":    "MOVLQSX",
+	"movd":      "MOVL",
+	"movdqa":    "MOVO",
+	"movdqu":    "MOVOU",
+	"cvtsi2ssl": "CVTSL2SS",
+	"cvtsi2ssq": "CVTSQ2SS",
+	"cvtsi2sdl": "CVTSL2SD",
+	"cvtsi2sdq": "CVTSQ2SD",
+}
+
+// unalignedMnemonics maps moves that fault on misaligned memory to the
+// equivalent moves without alignment requirements.
+var unalignedMnemonics = map[string]string{
+	"movaps":    "movups",
+	"movapd":    "movupd",
+	"movdqa":    "movdqu",
+	"vmovaps":   "vmovups",
+	"vmovapd":   "vmovupd",
+	"vmovdqa":   "vmovdqu",
+	"vmovdqa32": "vmovdqu32",
+	"vmovdqa64": "vmovdqu64",
+}
+
+// dataAlignment returns the alignment that the compiler gave to a data symbol.
+func dataAlignment(name string) int64 {
+	for _, symbol := range dataSymbols {
+		if symbol.Name == name {
+			return symbol.Alignment
+		}
+	}
+	return 0
+}
+
+// ripInstruction rewrites an instruction with a RIP-relative memory operand
+// into Go assembler syntax, so that the Go linker resolves the displacement.
+// Operands keep the AT&T order, which the Go assembler shares, except for
+// integer comparisons.
+func ripInstruction(asm string) (string, error) {
+	asm, _, _ = strings.Cut(asm, "#")
+	asm = strings.TrimSpace(asm)
+	if strings.ContainsAny(asm, "{}") {
+		return "", fmt.Errorf("unsupported PC-relative operand: %s", asm)
+	}
+	mnemonic, operandList, _ := strings.Cut(asm, "\t")
+	if strings.Contains(mnemonic, " ") {
+		mnemonic, operandList, _ = strings.Cut(asm, " ")
+	}
+	var operands []string
+	for _, operand := range strings.Split(operandList, ",") {
+		operand = strings.TrimSpace(operand)
+		switch {
+		case strings.HasPrefix(operand, "$"):
+			operands = append(operands, operand)
+		case strings.HasPrefix(operand, "%xmm"):
+			operands = append(operands, "X"+strings.TrimPrefix(operand, "%xmm"))
+		case strings.HasPrefix(operand, "%ymm"):
+			operands = append(operands, "Y"+strings.TrimPrefix(operand, "%ymm"))
+		case strings.HasPrefix(operand, "%zmm"):
+			operands = append(operands, "Z"+strings.TrimPrefix(operand, "%zmm"))
+		case strings.HasPrefix(operand, "%"):
+			operands = append(operands, amd64Register(operand))
+		default:
+			matches := ripOperand.FindStringSubmatch(operand)
+			if matches == nil {
+				return "", fmt.Errorf("unsupported PC-relative operand: %s", asm)
+			}
+			name := internal.DataSymbolName(matches[1])
+			// Go cannot align the symbol as the compiler assumed, which only
+			// matters to aligned moves, as other instructions with memory
+			// operands require no more than 16 bytes.
+			alignment := dataAlignment(name)
+			if unaligned, ok := unalignedMnemonics[mnemonic]; ok && alignment > internal.MaxDataAlignment {
+				_, _ = fmt.Fprintf(os.Stderr, "warning: %s is aligned to %d bytes, replacing %s with %s\n", name, alignment, mnemonic, unaligned)
+				mnemonic = unaligned
+			}
+			operands = append(operands, fmt.Sprintf("%s%s(SB)", internal.DataSymbolReference(name, dataSymbols), matches[2]))
+		}
+	}
+
+	op, ok := ripMnemonics[mnemonic]
+	switch {
+	case ok:
+	case mnemonic == "cvtss2si" || mnemonic == "cvtsd2si" || mnemonic == "cvttss2si" || mnemonic == "cvttsd2si":
+		// The size of the result is given by the destination register.
+		suffix := "L"
+		if strings.HasPrefix(operandList[strings.LastIndex(operandList, "%")+1:], "r") {
+			suffix = "Q"
+		}
+		op = strings.ToUpper(strings.TrimSuffix(mnemonic, "si")) + "S" + suffix
+	case strings.HasPrefix(mnemonic, "cmp") && len(mnemonic) == 4 && strings.ContainsAny(mnemonic[3:], "bwlq"):
+		op = strings.ToUpper(mnemonic)
+		slices.Reverse(operands)
+	case strings.HasPrefix(mnemonic, "cmp") || strings.HasPrefix(mnemonic, "vcmp"):
+		// Comparison predicates are encoded differently by the Go assembler.
+		return "", fmt.Errorf("unsupported PC-relative operand: %s", asm)
+	default:
+		op = strings.ToUpper(mnemonic)
+	}
+	return fmt.Sprintf("%s %s", op, strings.Join(operands, ", ")), nil
+}
+
 func init() {
 	internal.RegisterTarget("amd64", internal.Target{
-		GOARCH:             "amd64",
-		BuildTags:          "//go:build !noasm && amd64\n",
-		ClangTriple:        "amd64-linux-gnu",
+		GOARCH:      "amd64",
+		BuildTags:   "//go:build !noasm && amd64\n",
+		ClangTriple: "amd64-linux-gnu",
+		// No registers are reserved. The wrappers of ABI0 functions restore
+		// R14 and X15, and R15 is only clobbered when dynamically linking.
 		ClangOptions:       []string{"-mno-red-zone", "-mstackrealign"},
 		ParseAssembly:      parseAssembly,
 		ParseObjectDump:    parseObjectDump,
@@ -95,16 +238,81 @@ func init() {
 	})
 }
 
+// tailCall returns the jump mnemonic and the callee of a tail call. The
+// optimizer also folds tail calls into conditional jumps.
+func tailCall(asm string) (string, string, bool) {
+	if matches := tailLine.FindStringSubmatch(asm); matches != nil {
+		return matches[1], matches[2], true
+	}
+	return "", "", false
+}
+
+// generateTailCallBranch emits a conditional tail call as a branch to a
+// trampoline emitted after the function body.
+func generateTailCallBranch(builder *strings.Builder, tailCalls *[]string, function internal.Function, op, callee string) {
+	builder.WriteString(fmt.Sprintf("\t%s %s_tail%d\n", strings.ToUpper(op), function.Name, len(*tailCalls)))
+	*tailCalls = append(*tailCalls, callee)
+}
+
+// rewriteJumpTableDispatch rewrites the dispatch of a jump table, which adds the
+// loaded entry to the address of the table and jumps to the sum. The addition
+// is dropped, and the jump becomes a chain of comparisons of the loaded entry,
+// which holds the ordinal of the target label.
+func rewriteJumpTableDispatch(lines []internal.Line, index int) (string, bool) {
+	if index+1 < len(lines) {
+		base := baseLine.FindStringSubmatch(lines[index].Assembly)
+		jump := jumpLine.FindStringSubmatch(lines[index+1].Assembly)
+		if base != nil && jump != nil && base[2] == jump[1] {
+			_, ok := internal.JumpTable(lines, index, tableLine, dataSymbols)
+			return "", ok
+		}
+	}
+	jump := jumpLine.FindStringSubmatch(lines[index].Assembly)
+	if jump == nil || index == 0 {
+		return "", false
+	}
+	if base := baseLine.FindStringSubmatch(lines[index-1].Assembly); base == nil || base[2] != jump[1] {
+		return "", false
+	}
+	table, ok := internal.JumpTable(lines, index, tableLine, dataSymbols)
+	if !ok {
+		return "", false
+	}
+	var builder strings.Builder
+	last := len(table.Labels) - 1
+	for ordinal, label := range table.Labels[:last] {
+		builder.WriteString(fmt.Sprintf("\tCMPQ %s, $%d\n", amd64Register(jump[1]), ordinal))
+		builder.WriteString(fmt.Sprintf("\tJEQ %s\n", label))
+	}
+	builder.WriteString(fmt.Sprintf("\tJMP %s\n", table.Labels[last]))
+	return builder.String(), true
+}
+
 func generateLine(line internal.Line) string {
 	var builder strings.Builder
 	builder.WriteString("\t")
-	if strings.HasPrefix(line.Assembly, "j") {
+	if callee, ok := callTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("CALL %s<>(SB)", callee))
+	} else if strings.HasPrefix(line.Assembly, "j") && strings.Contains(line.Assembly, "*") {
+		_, _ = fmt.Fprintln(os.Stderr, "unsupported indirect jump:", line.Assembly)
+		os.Exit(1)
+	} else if strings.HasPrefix(line.Assembly, "j") {
 		splits := strings.Split(line.Assembly, ".")
 		op := strings.TrimSpace(splits[0])
 		operand := splits[1]
 		builder.WriteString(fmt.Sprintf("%s %s", strings.ToUpper(op), operand))
 	} else if matches := leaqRIPLine.FindStringSubmatch(line.Assembly); matches != nil {
-		builder.WriteString(fmt.Sprintf("LEAQ %s<>(SB), %s", matches[1], amd64Register(matches[2])))
+		builder.WriteString(fmt.Sprintf("LEAQ %s(SB), %s", internal.DataSymbolReference(matches[1], dataSymbols), amd64Register(matches[2])))
+	} else if matches := gotLine.FindStringSubmatch(line.Assembly); matches != nil {
+		// Load the address of the symbol instead of its GOT entry.
+		builder.WriteString(fmt.Sprintf("LEAQ %s(SB), %s", internal.DataSymbolReference(matches[1], dataSymbols), amd64Register(matches[2])))
+	} else if strings.Contains(line.Assembly, "(%rip)") {
+		asm, err := ripInstruction(line.Assembly)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		builder.WriteString(asm)
 	} else {
 		pos := 0
 		for pos < len(line.Binary) {
@@ -135,7 +343,7 @@ func generateLine(line internal.Line) string {
 	return builder.String()
 }
 
-func parseAssembly(path string) (map[string][]internal.Line, map[string]int, error) {
+func parseAssembly(path string, globals []internal.Global) (map[string][]internal.Line, map[string]int, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, nil, err
@@ -152,36 +360,26 @@ func parseAssembly(path string) (map[string][]internal.Line, map[string]int, err
 		functions    = make(map[string][]internal.Line)
 		functionName string
 		labelName    string
-		dataName     string
-		dataSection  bool
-		data         []internal.DataSymbol
+		data         = internal.DataCollector{JumpTables: true, Globals: globals}
 	)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, ".section") {
-			dataSection = strings.Contains(trimmed, ".rodata") || strings.Contains(trimmed, ".data")
-		}
-		if parsed, ok, err := internal.ParseDataDirective(line); err != nil {
+		if ok, err := data.Collect(line, 8, binary.LittleEndian); err != nil {
 			return nil, nil, err
-		} else if ok && dataName != "" {
-			data = append(data, internal.DataSymbol{Name: dataName, Data: parsed})
-			dataName = ""
-		} else if attributeLine.MatchString(line) {
+		} else if ok {
+			continue
+		}
+		if attributeLine.MatchString(line) {
 			continue
 		} else if nameLine.MatchString(line) {
 			name, _, _ := strings.Cut(line, ":")
 			if strings.HasPrefix(name, ".") {
 				continue
 			}
-			if dataSection {
-				dataName = name
-			} else {
-				functionName = name
-				functions[functionName] = make([]internal.Line, 0)
-				labelName = ""
-			}
+			functionName = name
+			functions[functionName] = make([]internal.Line, 0)
+			labelName = ""
 		} else if labelLine.MatchString(line) {
 			labelName = strings.Split(line, ":")[0]
 			labelName = labelName[1:]
@@ -211,7 +409,7 @@ func parseAssembly(path string) (map[string][]internal.Line, map[string]int, err
 	if err = scanner.Err(); err != nil {
 		return nil, nil, err
 	}
-	dataSymbols = data
+	dataSymbols = data.Symbols
 	return functions, stackSizes, nil
 }
 
@@ -229,7 +427,12 @@ func parseObjectDump(dump string, functions map[string][]internal.Line) error {
 	)
 	for i, line := range strings.Split(dump, "\n") {
 		line = strings.TrimSpace(line)
-		if symbolLine.MatchString(line) {
+		if relocation, ok := internal.ParseRelocation(line); ok {
+			if lineNumber > 0 {
+				lines := functions[functionName]
+				lines[lineNumber-1].Relocations = append(lines[lineNumber-1].Relocations, relocation)
+			}
+		} else if symbolLine.MatchString(line) {
 			functionName = strings.Split(line, "<")[1]
 			functionName = strings.Split(functionName, ">")[0]
 			lineNumber = 0
@@ -280,8 +483,22 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 	var builder strings.Builder
 	builder.WriteString(buildTags)
 	builder.WriteString(header)
-	builder.WriteString(internal.GenerateDataSymbols(dataSymbols, binary.LittleEndian))
+	data, err := internal.GenerateDataSymbols(dataSymbols, binary.LittleEndian)
+	if err != nil {
+		return err
+	}
+	builder.WriteString(data)
+	locals, err := internal.LocalFunctions(functions, dataSymbols, callTarget, tailCallTarget)
+	if err != nil {
+		return err
+	}
+	if err = internal.CheckRelocations(functions, locals, relocated); err != nil {
+		return err
+	}
 	for _, function := range functions {
+		if function.Local {
+			continue
+		}
 		returnSize := 0
 		if function.Type != "void" {
 			returnSize += 8
@@ -330,34 +547,80 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 			}
 			builder.WriteString("\tPUSHQ $0\n")
 		}
-		for _, line := range function.Lines {
+		var ret strings.Builder
+		if len(stack) > 0 {
+			for i := 0; i <= len(stack); i++ {
+				ret.WriteString("\tPOPQ DI\n")
+			}
+		}
+		if function.Type != "void" {
+			switch function.Type {
+			case "int64_t", "long", "_Bool":
+				ret.WriteString(fmt.Sprintf("\tMOVQ AX, result+%d(FP)\n", offset))
+			case "double":
+				ret.WriteString(fmt.Sprintf("\tMOVSD X0, result+%d(FP)\n", offset))
+			case "float":
+				ret.WriteString(fmt.Sprintf("\tMOVSS X0, result+%d(FP)\n", offset))
+			default:
+				return fmt.Errorf("unsupported return type: %v", function.Type)
+			}
+		}
+		ret.WriteString("\tRET\n")
+		var tailCalls []string
+		for i, line := range function.Lines {
 			for _, label := range line.Labels {
 				builder.WriteString(label)
 				builder.WriteString(":\n")
 			}
-			if line.Assembly == "retq" {
+			if op, callee, ok := tailCall(line.Assembly); ok {
+				// The callee must return to this function to store the result, so
+				// the tail call is lowered to a call, which pushes a return address
+				// in front of the stack arguments expected by the callee.
 				if len(stack) > 0 {
-					for i := 0; i <= len(stack); i++ {
-						builder.WriteString("\tPOPQ DI\n")
-					}
+					return fmt.Errorf("tail call in %s with stack arguments is not supported: %s", function.Name, line.Assembly)
 				}
-				if function.Type != "void" {
-					switch function.Type {
-					case "int64_t", "long", "_Bool":
-						builder.WriteString(fmt.Sprintf("\tMOVQ AX, result+%d(FP)\n", offset))
-					case "double":
-						builder.WriteString(fmt.Sprintf("\tMOVSD X0, result+%d(FP)\n", offset))
-					case "float":
-						builder.WriteString(fmt.Sprintf("\tMOVSS X0, result+%d(FP)\n", offset))
-					default:
-						return fmt.Errorf("unsupported return type: %v", function.Type)
-					}
+				if op == "jmp" {
+					builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+					builder.WriteString(ret.String())
+				} else {
+					generateTailCallBranch(&builder, &tailCalls, function, op, callee)
 				}
-				builder.WriteString("\tRET\n")
+			} else if line.Assembly == "retq" {
+				builder.WriteString(ret.String())
+			} else if dispatch, ok := rewriteJumpTableDispatch(function.Lines, i); ok {
+				builder.WriteString(dispatch)
+			} else {
+				builder.WriteString(generateLine(line))
+			}
+		}
+		for i, callee := range tailCalls {
+			builder.WriteString(fmt.Sprintf("%s_tail%d:\n", function.Name, i))
+			builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+			builder.WriteString(ret.String())
+		}
+	}
+	for _, function := range locals {
+		builder.WriteString(internal.LocalTextHeader(function.Name))
+		var tailCalls []string
+		for i, line := range function.Lines {
+			for _, label := range line.Labels {
+				builder.WriteString(label)
+				builder.WriteString(":\n")
+			}
+			if op, callee, ok := tailCall(line.Assembly); ok && op == "jmp" {
+				builder.WriteString(fmt.Sprintf("\tJMP %s<>(SB)\n", callee))
+			} else if ok {
+				generateTailCallBranch(&builder, &tailCalls, function, op, callee)
+			} else if dispatch, ok := rewriteJumpTableDispatch(function.Lines, i); ok {
+				builder.WriteString(dispatch)
 			} else {
 				builder.WriteString(generateLine(line))
 			}
 		}
+		for i, callee := range tailCalls {
+			builder.WriteString(fmt.Sprintf("%s_tail%d:\n", function.Name, i))
+			builder.WriteString(fmt.Sprintf("\tJMP %s<>(SB)\n", callee))
+		}
 	}
 
 	// write file
@@ -0,0 +1,634 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package arm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorse-io/goat/internal"
+	"github.com/klauspost/asmfmt"
+)
+
+var (
+	attributeLine = regexp.MustCompile(`^\s+\..+$`)
+	nameLine      = regexp.MustCompile(`^\w+:.*$`)
+	labelLine     = regexp.MustCompile(`^\.\w+_\d+:.*$`)
+	codeLine      = regexp.MustCompile(`^\s+\w+.+$`)
+	poolLabel     = regexp.MustCompile(`^\.(LCPI\w+):`)
+	jmpLine       = regexp.MustCompile(`^b(eq|ne|cs|hs|cc|lo|mi|pl|vs|vc|hi|ls|ge|lt|gt|le)?\s+\.(\w+_\d+)$`)
+
+	symbolLine    = regexp.MustCompile(`^\w+\s+<\w+>:$`)
+	dataLine      = regexp.MustCompile(`^\w+:\s+\w+\s+.+$`)
+	movwLine      = regexp.MustCompile(`^movw\s+(r\d+|lr), :lower16:\(?([A-Za-z_.$][\w.$]*(?:[+-]\d+)?)\)?$`)
+	movtLine      = regexp.MustCompile(`^movt\s+(r\d+|lr), :upper16:\(?([A-Za-z_.$][\w.$]*(?:[+-]\d+)?)\)?$`)
+	adrLine       = regexp.MustCompile(`^adr\s+(r\d+|lr), \.(LCPI\w+)$`)
+	poolLoad      = regexp.MustCompile(`^v?ldr\w*(?:\.\d+)?\s+.+, \.(LCPI\w+)$`)
+	callLine      = regexp.MustCompile(`^bl\s+([A-Za-z_][A-Za-z0-9_]*)$`)
+	tailLine      = regexp.MustCompile(`^b\s+([A-Za-z_][A-Za-z0-9_]*)$`)
+	saveLine      = regexp.MustCompile(`^(push|pop|vpush|vpop)\w*\s`)
+	alignmentHint = regexp.MustCompile(`:(128|256)\]`)
+
+	registers   = []string{"R0", "R1", "R2", "R3"}
+	dataSymbols []internal.DataSymbol
+)
+
+var (
+	callTarget     = internal.MatchCallee(callLine)
+	tailCallTarget = internal.MatchCallee(tailLine)
+)
+
+// conditions are the suffixes of Go branches, indexed by the condition field
+// of an instruction.
+var conditions = []string{"EQ", "NE", "CS", "CC", "MI", "PL", "VS", "VC", "HI", "LS", "GE", "LT", "GT", "LE", ""}
+
+// reservedRegisters are the registers reserved by Go. See
+// https://go.dev/doc/asm#arm
+var reservedRegisters = []internal.ReservedRegister{
+	// R10 points to the Go routine structure.
+	{Names: []string{"r10", "sl"}},
+	// R11 is the temporary register of the assembler, which holds the address
+	// of constant pools in the instructions that load from them.
+	{Names: []string{"r11", "fp"}},
+}
+
+// The code is compiled in the ARM encoding, since Go assembly cannot switch to
+// Thumb, and without jump tables, since they are placed inline in the text.
+// Constant pools in the text are emitted as read-only data symbols instead.
+func init() {
+	internal.RegisterTarget("arm", internal.Target{
+		GOARCH:      "arm",
+		BuildTags:   "//go:build !noasm && arm && arm.7\n",
+		ClangTriple: "armv7a-linux-gnueabihf",
+		Toolchain:   "arm-linux-gnueabihf",
+		LongSize:    4,
+		ClangOptions: []string{"-marm", "-mfpu=neon", "-mfloat-abi=hard",
+			"-fno-pic", "-fomit-frame-pointer", "-fno-jump-tables"},
+		ParseAssembly:      parseAssembly,
+		ParseObjectDump:    parseObjectDump,
+		GenerateGoAssembly: generateGoAssembly,
+	})
+}
+
+// relocated reports whether a relocation of an instruction is resolved by its
+// rewrite into Go assembly.
+func relocated(lines []internal.Line, index int, relocation internal.Relocation) bool {
+	asm := lines[index].Assembly
+	switch relocation.Type {
+	case "R_ARM_CALL":
+		_, ok := callTarget(asm)
+		return ok
+	case "R_ARM_JUMP24":
+		_, ok := tailCallTarget(asm)
+		return ok
+	case "R_ARM_MOVW_ABS_NC":
+		return movwLine.MatchString(asm)
+	case "R_ARM_MOVT_ABS":
+		return movtLine.MatchString(asm)
+	}
+	return false
+}
+
+// goRegister returns the Go name of a general purpose register.
+func goRegister(name string) string {
+	if name == "lr" {
+		return "R14"
+	}
+	return "R" + strings.TrimPrefix(name, "r")
+}
+
+func parseWord(line internal.Line) (uint32, error) {
+	word, err := strconv.ParseUint(line.Binary, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid instruction %q: %w", line.Binary, err)
+	}
+	return uint32(word), nil
+}
+
+// patchPoolLoad returns a PC-relative load from a constant pool, rewritten to
+// load from the address in R11 instead.
+func patchPoolLoad(line internal.Line) (string, error) {
+	word, err := parseWord(line)
+	if err != nil {
+		return "", err
+	}
+	if word>>16&0xf != 15 {
+		return "", fmt.Errorf("unsupported constant pool load %q", line.Assembly)
+	}
+	switch {
+	case word>>25&7 == 2: // LDR and LDRB
+		word &^= 0xfff
+	case word>>24&0xf == 0xd && word>>20&3 == 1 && word>>9&7 == 5: // VLDR
+		word &^= 0xff
+	case word>>25&7 == 0 && word>>22&1 == 1 && word>>4&9 == 9: // LDRD, LDRH, LDRSB and LDRSH
+		word &^= 0xf0f
+	default:
+		return "", fmt.Errorf("unsupported constant pool load %q", line.Assembly)
+	}
+	return fmt.Sprintf("%08x", word&^(0xf<<16)|11<<16|1<<23), nil
+}
+
+// returnInstruction reports whether an instruction returns to the caller, and
+// returns the suffix of its condition. An instruction that pops PC is patched to
+// pop LR instead, and the patched instruction is returned.
+func returnInstruction(line internal.Line) (string, string, bool) {
+	word, err := parseWord(line)
+	if err != nil {
+		return "", "", false
+	}
+	if word>>28 == 15 {
+		return "", "", false
+	}
+	cond := conditions[word>>28]
+	switch {
+	case word&0x0fffffff == 0x012fff1e: // bx lr
+		return cond, "", true
+	case word&0x0fff8000 == 0x08bd8000: // pop {..., pc}
+		word = word&^(1<<15) | 1<<14
+	case word&0x0fffffff == 0x049df004: // pop {pc}
+		word = word&^(0xf<<12) | 14<<12
+	default:
+		return "", "", false
+	}
+	return cond, fmt.Sprintf("\tWORD $0x%08x\t// %s\n", word, strings.Replace(line.Assembly, "pc", "lr", 1)), true
+}
+
+// lowerAlignmentHint returns a NEON load or store of multiple structures with
+// its alignment hint lowered to 8 bytes, the largest alignment that the Go
+// linker gives to data symbols on arm, such as constant pools. Lowering a hint
+// only skips the alignment check.
+func lowerAlignmentHint(line internal.Line) internal.Line {
+	word, err := parseWord(line)
+	if err != nil || word>>24 != 0xf4 || word>>23&1 != 0 || word>>4&3 < 2 {
+		return line
+	}
+	line.Binary = fmt.Sprintf("%08x", word&^(3<<4)|1<<4)
+	line.Assembly = alignmentHint.ReplaceAllString(line.Assembly, ":64]")
+	return line
+}
+
+func generateLine(line internal.Line) (string, error) {
+	var builder strings.Builder
+	if callee, ok := callTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+	} else if callee, ok := tailCallTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("\tJMP %s<>(SB)\n", callee))
+	} else if matches := jmpLine.FindStringSubmatch(line.Assembly); matches != nil {
+		builder.WriteString(fmt.Sprintf("\tB%s %s\n", strings.ToUpper(matches[1]), matches[2]))
+	} else if matches := movwLine.FindStringSubmatch(line.Assembly); matches != nil {
+		symbol, offset, _ := internal.SplitSymbolOffset(matches[2])
+		builder.WriteString(fmt.Sprintf("\tMOVW $%s+%d(SB), %s\n", internal.DataSymbolReference(symbol, dataSymbols), offset, goRegister(matches[1])))
+	} else if movtLine.MatchString(line.Assembly) {
+		// The upper half is loaded along with the lower half.
+	} else if matches := adrLine.FindStringSubmatch(line.Assembly); matches != nil {
+		builder.WriteString(fmt.Sprintf("\tMOVW $%s(SB), %s\n", internal.DataSymbolReference(matches[2], dataSymbols), goRegister(matches[1])))
+	} else if matches := poolLoad.FindStringSubmatch(line.Assembly); matches != nil {
+		binary, err := patchPoolLoad(line)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(fmt.Sprintf("\tMOVW $%s(SB), R11\n", internal.DataSymbolReference(matches[1], dataSymbols)))
+		builder.WriteString(fmt.Sprintf("\tWORD $0x%s\t// %s\n", binary, line.Assembly))
+	} else {
+		line = lowerAlignmentHint(line)
+		builder.WriteString(fmt.Sprintf("\tWORD $0x%s\t// %s\n", line.Binary, line.Assembly))
+	}
+	return builder.String(), nil
+}
+
+func parseAssembly(path string, globals []internal.Global) (map[string][]internal.Line, map[string]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func(file *os.File) {
+		if err = file.Close(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}(file)
+
+	var (
+		stackSizes   = make(map[string]int)
+		functions    = make(map[string][]internal.Line)
+		functionName string
+		labelName    string
+		data         = internal.DataCollector{Globals: globals}
+	)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := internal.StripComment(scanner.Text(), "@")
+		if ok, err := data.Collect(line, 4, binary.LittleEndian); err != nil {
+			return nil, nil, err
+		} else if ok {
+			continue
+		}
+		if data.Section() == internal.TextSection {
+			if matches := poolLabel.FindStringSubmatch(line); matches != nil {
+				data.Define(matches[1], internal.ReadOnlySection)
+				continue
+			} else if data.Name() != "" && strings.TrimSpace(line) != "" {
+				// A constant pool ends where the code resumes.
+				data.End()
+			}
+		}
+		if attributeLine.MatchString(line) {
+			continue
+		} else if nameLine.MatchString(line) {
+			name, _, _ := strings.Cut(line, ":")
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			functionName = name
+			functions[functionName] = make([]internal.Line, 0)
+		} else if labelLine.MatchString(line) {
+			labelName = strings.Split(line, ":")[0]
+			labelName = labelName[1:]
+			lines := functions[functionName]
+			if len(lines) == 1 || lines[len(lines)-1].Assembly != "" {
+				functions[functionName] = append(functions[functionName], internal.Line{Labels: []string{labelName}})
+			} else {
+				lines[len(lines)-1].Labels = append(lines[len(lines)-1].Labels, labelName)
+			}
+		} else if codeLine.MatchString(line) {
+			asm := strings.TrimSpace(line)
+			if labelName == "" {
+				functions[functionName] = append(functions[functionName], internal.Line{Assembly: asm})
+			} else {
+				lines := functions[functionName]
+				if len(lines) > 0 {
+					lines[len(lines)-1].Assembly = asm
+				}
+				labelName = ""
+			}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	dataSymbols = data.Symbols
+	return functions, stackSizes, nil
+}
+
+func parseObjectDump(dump string, functions map[string][]internal.Line) error {
+	var (
+		functionName string
+		lineNumber   int
+		inPool       bool
+	)
+	for i, line := range strings.Split(dump, "\n") {
+		line = strings.TrimSpace(line)
+		if relocation, ok := internal.ParseRelocation(line); ok {
+			// Relocations in constant pools are resolved by their data symbols.
+			if lineNumber > 0 && !inPool {
+				lines := functions[functionName]
+				lines[lineNumber-1].Relocations = append(lines[lineNumber-1].Relocations, relocation)
+			}
+		} else if symbolLine.MatchString(line) {
+			functionName = strings.Split(line, "<")[1]
+			functionName = strings.Split(functionName, ">")[0]
+			lineNumber = 0
+		} else if dataLine.MatchString(line) {
+			data := strings.Split(line, ":")[1]
+			data = strings.TrimSpace(data)
+			binary, assembly, _ := strings.Cut(data, " ")
+			assembly = strings.TrimSpace(assembly)
+			if inPool = strings.HasPrefix(assembly, "."); inPool {
+				// Constant pools are emitted as data symbols.
+				continue
+			}
+			lines := functions[functionName]
+			if assembly == "nop" && (lineNumber >= len(lines) || lines[lineNumber].Assembly != "nop") {
+				// Padding before aligned functions and constant pools.
+				continue
+			}
+			if lineNumber >= len(lines) {
+				return fmt.Errorf("%d: unexpected objectdump line: %s", i, line)
+			}
+			lines[lineNumber].Binary = binary
+			lineNumber++
+		}
+	}
+	return nil
+}
+
+// parameterSize returns the size of a parameter in the Go argument frame, where
+// 64-bit values are aligned to 4 bytes and long is int32.
+func parameterSize(param internal.Parameter) int {
+	if param.Pointer || param.Type == "long" {
+		return 4
+	}
+	return internal.SupportedTypes[param.Type]
+}
+
+// loadArgument returns the instructions that load a parameter into the Go
+// register or registers.
+func loadArgument(param internal.Parameter, offset int, registers ...string) string {
+	switch {
+	case param.Pointer || param.Type == "long":
+		return fmt.Sprintf("\tMOVW %s+%d(FP), %s\n", param.Name, offset, registers[0])
+	case param.Type == "_Bool":
+		return fmt.Sprintf("\tMOVBU %s+%d(FP), %s\n", param.Name, offset, registers[0])
+	case param.Type == "int64_t":
+		return fmt.Sprintf("\tMOVW %s_lo+%d(FP), %s\n\tMOVW %s_hi+%d(FP), %s\n",
+			param.Name, offset, registers[0], param.Name, offset+4, registers[1])
+	case param.Type == "float":
+		return fmt.Sprintf("\tMOVF %s+%d(FP), %s\n", param.Name, offset, registers[0])
+	default:
+		return fmt.Sprintf("\tMOVD %s+%d(FP), %s\n", param.Name, offset, registers[0])
+	}
+}
+
+// storeArgument returns the instructions that store a parameter into a stack
+// slot of the C callee, through R12 or F0.
+func storeArgument(param internal.Parameter, offset, slot int) string {
+	switch {
+	case param.Type == "int64_t" && !param.Pointer:
+		return fmt.Sprintf("\tMOVW %s_lo+%d(FP), R12\n\tMOVW R12, %d(R13)\n\tMOVW %s_hi+%d(FP), R12\n\tMOVW R12, %d(R13)\n",
+			param.Name, offset, slot, param.Name, offset+4, slot+4)
+	case param.Type == "float" && !param.Pointer:
+		return loadArgument(param, offset, "F0") + fmt.Sprintf("\tMOVF F0, %d(R13)\n", slot)
+	case param.Type == "double" && !param.Pointer:
+		return loadArgument(param, offset, "F0") + fmt.Sprintf("\tMOVD F0, %d(R13)\n", slot)
+	default:
+		return loadArgument(param, offset, "R12") + fmt.Sprintf("\tMOVW R12, %d(R13)\n", slot)
+	}
+}
+
+// stackArgument is a parameter passed in a stack slot.
+type stackArgument struct {
+	param  internal.Parameter
+	offset int
+	slot   int
+}
+
+// generateArguments returns the instructions that pass the parameters of a
+// function following AAPCS-VFP: integers and pointers in R0-R3, 64-bit integers
+// in even register pairs, and floating point values in S0-S15 and D0-D7, where
+// singles back-fill the halves left by doubles. The rest are passed in 4-byte
+// stack slots, 64-bit values aligned to 8 bytes. It also returns the size of the
+// Go argument frame and of the stack arguments.
+func generateArguments(params []internal.Parameter) (string, int, int) {
+	var (
+		loads      strings.Builder
+		stack      []stackArgument
+		offset     int
+		slot       int
+		core       int
+		singles    [16]bool
+		vfpOnStack bool
+	)
+	for _, param := range params {
+		sz := parameterSize(param)
+		if offset%min(sz, 4) != 0 {
+			offset += min(sz, 4) - offset%min(sz, 4)
+		}
+		switch {
+		case !param.Pointer && param.Type == "float":
+			s := -1
+			for i := 0; !vfpOnStack && i < len(singles); i++ {
+				if !singles[i] {
+					s = i
+					break
+				}
+			}
+			if s < 0 {
+				vfpOnStack = true
+				stack = append(stack, stackArgument{param: param, offset: offset, slot: slot})
+				slot += 4
+			} else if singles[s] = true; s%2 == 0 {
+				loads.WriteString(loadArgument(param, offset, fmt.Sprintf("F%d", s/2)))
+			} else {
+				// MOVF can only write the even single of a double register, so an
+				// odd single is moved from R12 by VMOV.
+				loads.WriteString(fmt.Sprintf("\tMOVW %s+%d(FP), R12\n", param.Name, offset))
+				loads.WriteString(fmt.Sprintf("\tWORD $0x%08x\t// vmov s%d, r12\n", 0xee00ca10|uint32(s>>1)<<16|uint32(s&1)<<7, s))
+			}
+		case !param.Pointer && param.Type == "double":
+			d := -1
+			for i := 0; !vfpOnStack && i < len(singles); i += 2 {
+				if !singles[i] && !singles[i+1] {
+					d = i / 2
+					break
+				}
+			}
+			if d < 0 {
+				vfpOnStack = true
+				slot += slot % 8
+				stack = append(stack, stackArgument{param: param, offset: offset, slot: slot})
+				slot += 8
+			} else {
+				singles[2*d], singles[2*d+1] = true, true
+				loads.WriteString(loadArgument(param, offset, fmt.Sprintf("F%d", d)))
+			}
+		case !param.Pointer && param.Type == "int64_t":
+			core += core % 2
+			if core+1 < len(registers) {
+				loads.WriteString(loadArgument(param, offset, registers[core], registers[core+1]))
+				core += 2
+			} else {
+				core = len(registers)
+				slot += slot % 8
+				stack = append(stack, stackArgument{param: param, offset: offset, slot: slot})
+				slot += 8
+			}
+		default:
+			if core < len(registers) {
+				loads.WriteString(loadArgument(param, offset, registers[core]))
+				core++
+			} else {
+				stack = append(stack, stackArgument{param: param, offset: offset, slot: slot})
+				slot += 4
+			}
+		}
+		offset += sz
+	}
+	if offset%4 != 0 {
+		offset += 4 - offset%4
+	}
+	stackArgumentSize := slot
+	if stackArgumentSize%8 != 0 {
+		stackArgumentSize += 8 - stackArgumentSize%8
+	}
+	// The Go assembler does not adjust the offsets from FP to SUB of R13, so the
+	// stack arguments are stored below R13 before it is moved past them.
+	var builder strings.Builder
+	for _, argument := range stack {
+		builder.WriteString(storeArgument(argument.param, argument.offset, argument.slot-stackArgumentSize))
+	}
+	builder.WriteString(loads.String())
+	if stackArgumentSize > 0 {
+		builder.WriteString(fmt.Sprintf("\tSUB $%d, R13\n", stackArgumentSize))
+	}
+	return builder.String(), offset, stackArgumentSize
+}
+
+func resultSize(typ string) int {
+	switch typ {
+	case "void":
+		return 0
+	case "_Bool":
+		return 1
+	case "float", "long":
+		return 4
+	default:
+		return 8
+	}
+}
+
+// storeResult returns the instructions that store the result of a function.
+func storeResult(typ string, offset int) (string, error) {
+	switch typ {
+	case "void":
+		return "", nil
+	case "_Bool":
+		return fmt.Sprintf("\tMOVB R0, result+%d(FP)\n", offset), nil
+	case "long":
+		return fmt.Sprintf("\tMOVW R0, result+%d(FP)\n", offset), nil
+	case "int64_t":
+		return fmt.Sprintf("\tMOVW R0, result_lo+%d(FP)\n\tMOVW R1, result_hi+%d(FP)\n", offset, offset+4), nil
+	case "float":
+		return fmt.Sprintf("\tMOVF F0, result+%d(FP)\n", offset), nil
+	case "double":
+		return fmt.Sprintf("\tMOVD F0, result+%d(FP)\n", offset), nil
+	default:
+		return "", fmt.Errorf("unsupported return type: %v", typ)
+	}
+}
+
+// withoutSaves returns the functions without the instructions that save and
+// restore callee-saved registers, which keep the reserved registers intact.
+func withoutSaves(functions []internal.Function) []internal.Function {
+	var stripped []internal.Function
+	for _, function := range functions {
+		var lines []internal.Line
+		for _, line := range function.Lines {
+			if !saveLine.MatchString(line.Assembly) {
+				lines = append(lines, line)
+			}
+		}
+		function.Lines = lines
+		stripped = append(stripped, function)
+	}
+	return stripped
+}
+
+func generateGoAssembly(buildTags string, header string, goAssemblyPath string, functions []internal.Function) error {
+	// generate code
+	var builder strings.Builder
+	builder.WriteString(buildTags)
+	builder.WriteString(header)
+	if err := internal.CheckDataAlignment(dataSymbols); err != nil {
+		return err
+	}
+	data, err := internal.GenerateDataSymbols(dataSymbols, binary.LittleEndian)
+	if err != nil {
+		return err
+	}
+	builder.WriteString(data)
+	locals, err := internal.LocalFunctions(functions, dataSymbols, callTarget, tailCallTarget)
+	if err != nil {
+		return err
+	}
+	if err = internal.CheckRelocations(functions, locals, relocated); err != nil {
+		return err
+	}
+	if err = internal.CheckReservedRegisters(withoutSaves(functions), withoutSaves(locals), reservedRegisters); err != nil {
+		return err
+	}
+	for _, function := range functions {
+		if function.Local {
+			continue
+		}
+		arguments, offset, stackArgumentSize := generateArguments(function.Parameters)
+		returnLabel := fmt.Sprintf("%s_return", function.Name)
+		builder.WriteString(fmt.Sprintf("\nTEXT ·%v(SB), $0-%d\n", function.Name, offset+resultSize(function.Type)))
+		builder.WriteString(arguments)
+		for _, line := range function.Lines {
+			for _, label := range line.Labels {
+				builder.WriteString(label)
+				builder.WriteString(":\n")
+			}
+			if line.Assembly == "" {
+				continue
+			}
+			if callee, ok := tailCallTarget(line.Assembly); ok {
+				// The callee must return here to store the result.
+				builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+				builder.WriteString(fmt.Sprintf("\tB %s\n", returnLabel))
+			} else if cond, restore, ok := returnInstruction(line); ok {
+				builder.WriteString(restore)
+				builder.WriteString(fmt.Sprintf("\tB%s %s\n", cond, returnLabel))
+			} else {
+				code, err := generateLine(line)
+				if err != nil {
+					return fmt.Errorf("function %s: %w", function.Name, err)
+				}
+				builder.WriteString(code)
+			}
+		}
+		builder.WriteString(returnLabel)
+		builder.WriteString(":\n")
+		if stackArgumentSize > 0 {
+			builder.WriteString(fmt.Sprintf("\tADD $%d, R13\n", stackArgumentSize))
+		}
+		result, err := storeResult(function.Type, offset)
+		if err != nil {
+			return err
+		}
+		builder.WriteString(result)
+		builder.WriteString("\tRET\n")
+	}
+	for _, function := range locals {
+		builder.WriteString(internal.LocalTextHeader(function.Name))
+		for _, line := range function.Lines {
+			for _, label := range line.Labels {
+				builder.WriteString(label)
+				builder.WriteString(":\n")
+			}
+			if line.Assembly == "" {
+				continue
+			}
+			code, err := generateLine(line)
+			if err != nil {
+				return fmt.Errorf("function %s: %w", function.Name, err)
+			}
+			builder.WriteString(code)
+		}
+	}
+
+	// write file
+	f, err := os.Create(goAssemblyPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		if err = f.Close(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}(f)
+	bytes, err := asmfmt.Format(strings.NewReader(builder.String()))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(bytes)
+	return err
+}
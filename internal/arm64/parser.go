@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -32,36 +33,240 @@ var (
 	nameLine      = regexp.MustCompile(`^\w+:.*$`)
 	labelLine     = regexp.MustCompile(`^\.\w+_\d+:.*$`)
 	codeLine      = regexp.MustCompile(`^\s+\w+.+$`)
-	jmpLine       = regexp.MustCompile(`^(b|b\.\w{2})\t\.\w+_\d+$`)
+	jmpLine       = regexp.MustCompile(`^(b|b\.\w{2,5})\t\.\w+_\d+$`)
 
 	symbolLine = regexp.MustCompile(`^\w+\s+<\w+>:$`)
 	dataLine   = regexp.MustCompile(`^\w+:\s+\w+\s+.+$`)
-	adrpLine   = regexp.MustCompile(`^adrp\s+x([0-9]+), ([A-Za-z_][A-Za-z0-9_]*)$`)
-	lo12Line   = regexp.MustCompile(`^add\s+x([0-9]+), x([0-9]+), :lo12:([A-Za-z_][A-Za-z0-9_]*)$`)
+	adrpLine   = regexp.MustCompile(`^adrp\s+x([0-9]+), ([A-Za-z_.$][\w.$]*)(\+\d+)?$`)
+	adrLine    = regexp.MustCompile(`^adr\s+x([0-9]+), ([A-Za-z_.$][\w.$]*(?:\+\d+)?)$`)
+	gotPage    = regexp.MustCompile(`^adrp\s+x([0-9]+), :got:([A-Za-z_.$][\w.$]*)$`)
+	gotLoad    = regexp.MustCompile(`^ldr\s+x([0-9]+), \[x([0-9]+), :got_lo12:([A-Za-z_.$][\w.$]*)\]$`)
+	lo12Symbol = regexp.MustCompile(`:lo12:([A-Za-z_.$][\w.$]*(?:\+\d+)?)`)
+	baseLine   = regexp.MustCompile(`^adr\s+x([0-9]+), \.L\w+$`)
+	entryLine  = regexp.MustCompile(`^add\s+x([0-9]+), x([0-9]+), x([0-9]+)(?:, lsl #\d)?$`)
+	branchLine = regexp.MustCompile(`^br\s+x([0-9]+)$`)
+	tableLine  = regexp.MustCompile(`\.(LJTI\w+)`)
+	callLine   = regexp.MustCompile(`^bl\s+([A-Za-z_][A-Za-z0-9_]*)$`)
+	tailLine   = regexp.MustCompile(`^b\s+([A-Za-z_][A-Za-z0-9_]*)$`)
+
+	registerOperand = regexp.MustCompile(`\w+`)
+	smeOperand      = regexp.MustCompile(`^(za\d*[hv]?|zt0|svcr\w*)$`)
 
 	registers   = []string{"R0", "R1", "R2", "R3", "R4", "R5", "R6", "R7"}
 	fpRegisters = []string{"F0", "F1", "F2", "F3", "F4", "F5", "F6", "F7"}
 	dataSymbols []internal.DataSymbol
 )
 
+var (
+	callTarget     = internal.MatchCallee(callLine)
+	tailCallTarget = internal.MatchCallee(tailLine)
+)
+
+// reservedRegisters are the registers reserved by Go. See
+// https://go.dev/doc/asm#arm64
+var reservedRegisters = []internal.ReservedRegister{
+	// R18 is the "platform register", reserved on the Apple platform.
+	{Names: []string{"x18", "w18"}, Option: "-ffixed-x18"},
+	// R27 is the temporary register of the assembler, which may be used by the
+	// instructions that replace compiler-generated ones.
+	{Names: []string{"x27", "w27"}, Option: "-ffixed-x27"},
+	// R28 points to the Go routine structure.
+	{Names: []string{"x28", "w28"}, Option: "-ffixed-x28"},
+}
+
+// hwcaps are the SVE features, as named by HWCAP_* and HWCAP2_* of Linux.
+var hwcaps = []internal.HWCap{
+	{Name: "SVE", Macro: "__ARM_FEATURE_SVE", Entry: internal.AtHWCap, Bit: 22},
+	{Name: "SVE2", Macro: "__ARM_FEATURE_SVE2", Entry: internal.AtHWCap2, Bit: 1},
+	{Name: "SVEAES", Macro: "__ARM_FEATURE_SVE2_AES", Entry: internal.AtHWCap2, Bit: 2},
+	{Name: "SVEPMULL", Macro: "__ARM_FEATURE_SVE2_AES", Entry: internal.AtHWCap2, Bit: 3},
+	{Name: "SVEBITPERM", Macro: "__ARM_FEATURE_SVE2_BITPERM", Entry: internal.AtHWCap2, Bit: 4},
+	{Name: "SVESHA3", Macro: "__ARM_FEATURE_SVE2_SHA3", Entry: internal.AtHWCap2, Bit: 5},
+	{Name: "SVESM4", Macro: "__ARM_FEATURE_SVE2_SM4", Entry: internal.AtHWCap2, Bit: 6},
+	{Name: "SVEI8MM", Macro: "__ARM_FEATURE_SVE_MATMUL_INT8", Entry: internal.AtHWCap2, Bit: 9},
+	{Name: "SVEF32MM", Macro: "__ARM_FEATURE_SVE_MATMUL_FP32", Entry: internal.AtHWCap2, Bit: 10},
+	{Name: "SVEF64MM", Macro: "__ARM_FEATURE_SVE_MATMUL_FP64", Entry: internal.AtHWCap2, Bit: 11},
+	{Name: "SVEBF16", Macro: "__ARM_FEATURE_SVE_BF16", Entry: internal.AtHWCap2, Bit: 12},
+}
+
+// conditionAliases maps the condition codes that SVE names after predicate
+// tests to the ones that Go knows.
+var conditionAliases = map[string]string{
+	"none":  "eq",
+	"any":   "ne",
+	"nlast": "hs",
+	"last":  "lo",
+	"first": "mi",
+	"nfrst": "pl",
+	"pmore": "hi",
+	"plast": "ls",
+	"tcont": "ge",
+	"tstop": "lt",
+}
+
 func init() {
 	internal.RegisterTarget("arm64", internal.Target{
-		GOARCH:      "arm64",
-		BuildTags:   "//go:build !noasm && arm64\n",
-		ClangTriple: "aarch64-linux-gnu",
-		// R18 is the "platform register", reserved on the Apple platform.
-		// See https://go.dev/doc/asm#arm64
-		ClangOptions:       []string{"-ffixed-x18"},
+		GOARCH:             "arm64",
+		BuildTags:          "//go:build !noasm && arm64\n",
+		ClangTriple:        "aarch64-linux-gnu",
+		ClangOptions:       internal.ReservedRegisterOptions(reservedRegisters),
+		HWCaps:             hwcaps,
 		ParseAssembly:      parseAssembly,
 		ParseObjectDump:    parseObjectDump,
 		GenerateGoAssembly: generateGoAssembly,
 	})
 }
 
+// checkStreamingMode returns an error for the first instruction of an emitted
+// function that enters the streaming mode of SME or uses its ZA storage. Go
+// expects the non-streaming mode, where all SIMD and floating-point
+// instructions are legal, and inactive ZA at calls and returns. SVE code runs
+// in the non-streaming mode, and its Z and P registers are not preserved by Go,
+// so they can be clobbered freely.
+func checkStreamingMode(functions, locals []internal.Function) error {
+	for _, function := range internal.EmittedFunctions(functions, locals) {
+		for _, line := range function.Lines {
+			fields := strings.Fields(strings.ToLower(line.Assembly))
+			if len(fields) == 0 {
+				continue
+			}
+			streaming := fields[0] == "smstart" || fields[0] == "smstop"
+			for _, operand := range registerOperand.FindAllString(strings.Join(fields[1:], " "), -1) {
+				streaming = streaming || smeOperand.MatchString(operand)
+			}
+			if streaming {
+				return fmt.Errorf("function %s: streaming mode and ZA storage are not supported in %q",
+					function.Name, line.Assembly)
+			}
+		}
+	}
+	return nil
+}
+
+// lo12Scales maps the relocations of the low 12 bits of an address to the
+// scale of the immediate they fix up.
+var lo12Scales = map[string]int64{
+	"R_AARCH64_ADD_ABS_LO12_NC":     1,
+	"R_AARCH64_LDST8_ABS_LO12_NC":   1,
+	"R_AARCH64_LDST16_ABS_LO12_NC":  2,
+	"R_AARCH64_LDST32_ABS_LO12_NC":  4,
+	"R_AARCH64_LDST64_ABS_LO12_NC":  8,
+	"R_AARCH64_LDST128_ABS_LO12_NC": 16,
+}
+
+// relocated reports whether a relocation of an instruction is resolved by its
+// rewrite into Go assembly.
+func relocated(lines []internal.Line, index int, relocation internal.Relocation) bool {
+	asm := lines[index].Assembly
+	switch relocation.Type {
+	case "R_AARCH64_CALL26":
+		_, ok := callTarget(asm)
+		return ok
+	case "R_AARCH64_JUMP26":
+		_, ok := tailCallTarget(asm)
+		return ok
+	case "R_AARCH64_ADR_PREL_PG_HI21":
+		return adrpLine.MatchString(asm)
+	case "R_AARCH64_ADR_PREL_LO21":
+		return adrLine.MatchString(asm)
+	case "R_AARCH64_ADR_GOT_PAGE":
+		return gotPage.MatchString(asm)
+	case "R_AARCH64_LD64_GOT_LO12_NC":
+		return gotLoad.MatchString(asm)
+	}
+	if _, ok := lo12Scales[relocation.Type]; ok {
+		_, err := patchLo12(lines[index], relocation)
+		return err == nil
+	}
+	return false
+}
+
+// patchLo12 returns the binary of an instruction that adds the low 12 bits of
+// a symbol address, with the immediate replaced by the offset from the symbol.
+// The preceding ADRP is rewritten to load the full address of the Go symbol.
+func patchLo12(line internal.Line, relocation internal.Relocation) (string, error) {
+	matches := lo12Symbol.FindStringSubmatch(line.Assembly)
+	if matches == nil {
+		return "", fmt.Errorf("missing :lo12: operand in %q", line.Assembly)
+	}
+	_, offset, ok := internal.SplitSymbolOffset(matches[1])
+	if !ok {
+		return "", fmt.Errorf("unsupported operand %q", matches[1])
+	}
+	scale := lo12Scales[relocation.Type]
+	if offset%scale != 0 || offset/scale > 0xfff {
+		return "", fmt.Errorf("offset %d out of range in %q", offset, line.Assembly)
+	}
+	word, err := strconv.ParseUint(line.Binary, 16, 32)
+	if err != nil {
+		return "", err
+	}
+	word = word&^(0xfff<<10) | uint64(offset/scale)<<10
+	return fmt.Sprintf("%08x", word), nil
+}
+
+// dispatchEntry returns the index of the addition of a loaded jump table entry to
+// the base label, which precedes the indirect branch of a jump table.
+func dispatchEntry(lines []internal.Line, index int) (int, bool) {
+	for i := index; i+1 < len(lines); i++ {
+		entry := entryLine.FindStringSubmatch(lines[i].Assembly)
+		branch := branchLine.FindStringSubmatch(lines[i+1].Assembly)
+		if entry != nil && branch != nil && entry[1] == branch[1] {
+			_, ok := internal.JumpTable(lines, i, tableLine, dataSymbols)
+			return i, ok
+		}
+		if branchLine.MatchString(lines[i].Assembly) {
+			break
+		}
+	}
+	return 0, false
+}
+
+// rewriteJumpTableDispatch rewrites the dispatch of a jump table, which adds the
+// loaded entry to the address of a base label and branches to the sum. The base
+// label and the addition are dropped, and the branch becomes a chain of
+// comparisons of the loaded entry, which holds the ordinal of the target label.
+func rewriteJumpTableDispatch(lines []internal.Line, index int) (string, bool) {
+	asm := lines[index].Assembly
+	if base := baseLine.FindStringSubmatch(asm); base != nil && len(lines[index].Relocations) == 0 {
+		i, ok := dispatchEntry(lines, index)
+		return "", ok && entryLine.FindStringSubmatch(lines[i].Assembly)[2] == base[1]
+	}
+	if entryLine.MatchString(asm) {
+		i, ok := dispatchEntry(lines, index)
+		return "", ok && i == index
+	}
+	if !branchLine.MatchString(asm) || index == 0 {
+		return "", false
+	}
+	if i, ok := dispatchEntry(lines, index-1); !ok || i != index-1 {
+		return "", false
+	}
+	table, _ := internal.JumpTable(lines, index, tableLine, dataSymbols)
+	entry := entryLine.FindStringSubmatch(lines[index-1].Assembly)
+	var builder strings.Builder
+	last := len(table.Labels) - 1
+	for ordinal, label := range table.Labels[:last] {
+		builder.WriteString(fmt.Sprintf("\tCMP $%d, R%s\n", ordinal, entry[3]))
+		builder.WriteString(fmt.Sprintf("\tBEQ %s\n", label))
+	}
+	builder.WriteString(fmt.Sprintf("\tJMP %s\n", table.Labels[last]))
+	return builder.String(), true
+}
+
 func generateLine(line internal.Line) string {
 	var builder strings.Builder
-	if jmpLine.MatchString(line.Assembly) {
+	if callee, ok := callTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+	} else if callee, ok := tailCallTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("\tJMP %s<>(SB)\n", callee))
+	} else if jmpLine.MatchString(line.Assembly) {
 		splits := strings.Split(line.Assembly, "\t")
+		if mnemonic, condition, ok := strings.Cut(splits[0], "."); ok {
+			if alias, ok := conditionAliases[condition]; ok {
+				splits[0] = mnemonic + "." + alias
+			}
+		}
 		instruction := strings.Map(func(r rune) rune {
 			if r == '.' {
 				return -1
@@ -71,12 +276,29 @@ func generateLine(line internal.Line) string {
 		label := splits[1][1:]
 		builder.WriteString(fmt.Sprintf("%s %s\n", instruction, label))
 	} else if matches := adrpLine.FindStringSubmatch(line.Assembly); matches != nil {
-		builder.WriteString(fmt.Sprintf("\tMOVD $%s<>(SB), R%s\n", matches[2], matches[1]))
-	} else if lo12Line.MatchString(line.Assembly) {
-		// The preceding ADRP is rewritten to load the full Go symbol address.
+		builder.WriteString(fmt.Sprintf("\tMOVD $%s(SB), R%s\n", internal.DataSymbolReference(matches[2], dataSymbols), matches[1]))
+	} else if matches := adrLine.FindStringSubmatch(line.Assembly); matches != nil && len(line.Relocations) > 0 {
+		symbol, offset, _ := internal.SplitSymbolOffset(matches[2])
+		builder.WriteString(fmt.Sprintf("\tMOVD $%s+%d(SB), R%s\n", internal.DataSymbolReference(symbol, dataSymbols), offset, matches[1]))
+	} else if matches := gotPage.FindStringSubmatch(line.Assembly); matches != nil {
+		// Load the address of the symbol instead of its GOT entry.
+		builder.WriteString(fmt.Sprintf("\tMOVD $%s(SB), R%s\n", internal.DataSymbolReference(matches[2], dataSymbols), matches[1]))
+	} else if matches := gotLoad.FindStringSubmatch(line.Assembly); matches != nil {
+		builder.WriteString(fmt.Sprintf("\tMOVD R%s, R%s\n", matches[2], matches[1]))
 	} else {
+		binary := line.Binary
+		for _, relocation := range line.Relocations {
+			if _, ok := lo12Scales[relocation.Type]; ok {
+				patched, err := patchLo12(line, relocation)
+				if err != nil {
+					_, _ = fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				binary = patched
+			}
+		}
 		builder.WriteString("\t")
-		builder.WriteString(fmt.Sprintf("WORD $0x%v", line.Binary))
+		builder.WriteString(fmt.Sprintf("WORD $0x%v", binary))
 		builder.WriteString("\t// ")
 		builder.WriteString(line.Assembly)
 		builder.WriteString("\n")
@@ -84,7 +306,7 @@ func generateLine(line internal.Line) string {
 	return builder.String()
 }
 
-func parseAssembly(path string) (map[string][]internal.Line, map[string]int, error) {
+func parseAssembly(path string, globals []internal.Global) (map[string][]internal.Line, map[string]int, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, nil, err
@@ -101,35 +323,25 @@ func parseAssembly(path string) (map[string][]internal.Line, map[string]int, err
 		functions    = make(map[string][]internal.Line)
 		functionName string
 		labelName    string
-		dataName     string
-		dataSection  bool
-		data         []internal.DataSymbol
+		data         = internal.DataCollector{JumpTables: true, Globals: globals}
 	)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, ".section") {
-			dataSection = strings.Contains(trimmed, ".rodata") || strings.Contains(trimmed, ".data")
-		}
-		if parsed, ok, err := internal.ParseDataDirective(line); err != nil {
+		if ok, err := data.Collect(line, 8, binary.LittleEndian); err != nil {
 			return nil, nil, err
-		} else if ok && dataName != "" {
-			data = append(data, internal.DataSymbol{Name: dataName, Data: parsed})
-			dataName = ""
-		} else if attributeLine.MatchString(line) {
+		} else if ok {
+			continue
+		}
+		if attributeLine.MatchString(line) {
 			continue
 		} else if nameLine.MatchString(line) {
 			name, _, _ := strings.Cut(line, ":")
 			if strings.HasPrefix(name, ".") {
 				continue
 			}
-			if dataSection {
-				dataName = name
-			} else {
-				functionName = name
-				functions[functionName] = make([]internal.Line, 0)
-			}
+			functionName = name
+			functions[functionName] = make([]internal.Line, 0)
 		} else if labelLine.MatchString(line) {
 			labelName = strings.Split(line, ":")[0]
 			labelName = labelName[1:]
@@ -157,7 +369,7 @@ func parseAssembly(path string) (map[string][]internal.Line, map[string]int, err
 	if err = scanner.Err(); err != nil {
 		return nil, nil, err
 	}
-	dataSymbols = data
+	dataSymbols = data.Symbols
 	return functions, stackSizes, nil
 }
 
@@ -168,7 +380,12 @@ func parseObjectDump(dump string, functions map[string][]internal.Line) error {
 	)
 	for i, line := range strings.Split(dump, "\n") {
 		line = strings.TrimSpace(line)
-		if symbolLine.MatchString(line) {
+		if relocation, ok := internal.ParseRelocation(line); ok {
+			if lineNumber > 0 {
+				lines := functions[functionName]
+				lines[lineNumber-1].Relocations = append(lines[lineNumber-1].Relocations, relocation)
+			}
+		} else if symbolLine.MatchString(line) {
 			functionName = strings.Split(line, "<")[1]
 			functionName = strings.Split(functionName, ">")[0]
 			lineNumber = 0
@@ -203,8 +420,31 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 	var builder strings.Builder
 	builder.WriteString(buildTags)
 	builder.WriteString(header)
-	builder.WriteString(internal.GenerateDataSymbols(dataSymbols, binary.LittleEndian))
+	if err := internal.CheckDataAlignment(dataSymbols); err != nil {
+		return err
+	}
+	data, err := internal.GenerateDataSymbols(dataSymbols, binary.LittleEndian)
+	if err != nil {
+		return err
+	}
+	builder.WriteString(data)
+	locals, err := internal.LocalFunctions(functions, dataSymbols, callTarget, tailCallTarget)
+	if err != nil {
+		return err
+	}
+	if err = internal.CheckRelocations(functions, locals, relocated); err != nil {
+		return err
+	}
+	if err = internal.CheckReservedRegisters(functions, locals, reservedRegisters); err != nil {
+		return err
+	}
+	if err = checkStreamingMode(functions, locals); err != nil {
+		return err
+	}
 	for _, function := range functions {
+		if function.Local {
+			continue
+		}
 		returnSize := 0
 		if function.Type != "void" {
 			returnSize += 8
@@ -264,12 +504,17 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 		builder.WriteString(fmt.Sprintf("\nTEXT ·%v(SB), $%d-%d\n",
 			function.Name, stackOffset, offset+returnSize))
 		builder.WriteString(argsBuilder.String())
-		for _, line := range function.Lines {
+		for i, line := range function.Lines {
 			for _, label := range line.Labels {
 				builder.WriteString(label)
 				builder.WriteString(":\n")
 			}
-			if line.Assembly == "ret" {
+			callee, isTailCall := tailCallTarget(line.Assembly)
+			if isTailCall {
+				// The callee must return here to store the result.
+				builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+			}
+			if isTailCall || line.Assembly == "ret" {
 				if function.Type != "void" {
 					switch function.Type {
 					case "int64_t", "long", "_Bool":
@@ -283,6 +528,22 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 					}
 				}
 				builder.WriteString("\tRET\n")
+			} else if dispatch, ok := rewriteJumpTableDispatch(function.Lines, i); ok {
+				builder.WriteString(dispatch)
+			} else {
+				builder.WriteString(generateLine(line))
+			}
+		}
+	}
+	for _, function := range locals {
+		builder.WriteString(internal.LocalTextHeader(function.Name))
+		for i, line := range function.Lines {
+			for _, label := range line.Labels {
+				builder.WriteString(label)
+				builder.WriteString(":\n")
+			}
+			if dispatch, ok := rewriteJumpTableDispatch(function.Lines, i); ok {
+				builder.WriteString(dispatch)
 			} else {
 				builder.WriteString(generateLine(line))
 			}
@@ -0,0 +1,115 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// MatchCallee returns a function that extracts the callee of a call instruction
+// matched by re, which captures the callee in its last group.
+func MatchCallee(re *regexp.Regexp) func(string) (string, bool) {
+	return func(asm string) (string, bool) {
+		if matches := re.FindStringSubmatch(asm); matches != nil {
+			return matches[len(matches)-1], true
+		}
+		return "", false
+	}
+}
+
+// LocalFunctions returns the functions that must be emitted as private Go
+// assembly symbols following the C calling convention: static helpers and
+// exported functions called from translated code. Local functions that are
+// unreachable from exported functions are dropped. Each of targets extracts the
+// callee of a kind of call instruction, such as calls and tail calls. Functions
+// whose addresses are stored in data symbols are called indirectly. Calls to
+// symbols that are not defined in the translation unit are reported as errors.
+func LocalFunctions(functions []Function, symbols []DataSymbol, targets ...func(string) (string, bool)) ([]Function, error) {
+	defined := make(map[string]*Function)
+	var queue []*Function
+	for i := range functions {
+		defined[functions[i].Name] = &functions[i]
+		if !functions[i].Local {
+			queue = append(queue, &functions[i])
+		}
+	}
+	called := make(map[string]bool)
+	for _, symbol := range symbols {
+		for _, pointer := range symbol.Pointers {
+			if function, ok := defined[pointer.Symbol]; ok {
+				if !called[pointer.Symbol] {
+					called[pointer.Symbol] = true
+					queue = append(queue, function)
+				}
+			} else if !slices.ContainsFunc(symbols, func(target DataSymbol) bool { return target.Name == pointer.Symbol }) {
+				return nil, fmt.Errorf("data symbol %s refers to undefined symbol %s", symbol.Name, pointer.Symbol)
+			}
+		}
+	}
+	for len(queue) > 0 {
+		function := queue[0]
+		queue = queue[1:]
+		for _, callee := range Callees(*function, targets...) {
+			if _, ok := defined[callee]; !ok {
+				return nil, fmt.Errorf("function %s calls undefined function %s", function.Name, callee)
+			}
+			if !called[callee] {
+				called[callee] = true
+				queue = append(queue, defined[callee])
+			}
+		}
+	}
+
+	var locals []Function
+	for _, function := range functions {
+		if len(function.Lines) > 0 && called[function.Name] {
+			locals = append(locals, function)
+		}
+	}
+	return locals, nil
+}
+
+// Callees returns the distinct callees of a function in the order they are
+// called. Each of targets extracts the callee of a kind of call instruction.
+func Callees(function Function, targets ...func(string) (string, bool)) []string {
+	var callees []string
+	for _, line := range function.Lines {
+		for _, target := range targets {
+			if callee, ok := target(line.Assembly); ok && !slices.Contains(callees, callee) {
+				callees = append(callees, callee)
+			}
+		}
+	}
+	return callees
+}
+
+// EmittedFunctions returns the functions that are emitted, which are the
+// exported functions and locals.
+func EmittedFunctions(functions, locals []Function) []Function {
+	var emitted []Function
+	for _, function := range functions {
+		if !function.Local {
+			emitted = append(emitted, function)
+		}
+	}
+	return append(emitted, locals...)
+}
+
+// LocalTextHeader returns the TEXT directive of a private function following
+// the C calling convention. The frame is managed by the C code itself.
+func LocalTextHeader(name string) string {
+	return fmt.Sprintf("\nTEXT %s<>(SB), %d, $0-0\n", name, NOSPLIT|NOFRAME)
+}
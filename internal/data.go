@@ -16,47 +16,670 @@ package internal
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 )
 
-// DataSymbol is a constant data object collected from compiler-generated data
-// sections and emitted as Go asm DATA/GLOBL directives.
+// DataSymbol is a data object collected from compiler-generated data sections
+// and emitted as Go asm DATA/GLOBL directives.
 type DataSymbol struct {
-	Name string
-	Data []byte
+	Name      string
+	Data      []byte
+	Section   Section
+	Alignment int64
+	// Labels are the distinct code labels targeted by a jump table. Its entries
+	// hold the ordinals of their labels, since Go assembly cannot store the
+	// addresses of code labels in data.
+	Labels []string
+	// Pointers are the addresses of symbols stored in the data, the bytes of
+	// which are zero until they are resolved.
+	Pointers []DataPointer
+	// Exported is set for the symbol of a C global that is exported as a Go
+	// package-level variable.
+	Exported bool
 }
 
-// ParseDataDirective parses data directives that embed literal bytes in clang
-// assembly output.
-func ParseDataDirective(line string) ([]byte, bool, error) {
+// DataPointer is the address of a data symbol or a function plus an addend,
+// stored at an offset in a data symbol. If Base is set, it is the distance of
+// the address from Base instead.
+type DataPointer struct {
+	Offset int
+	Size   int
+	Symbol string
+	Base   string
+	Addend int64
+}
+
+var (
+	dataLabelLine  = regexp.MustCompile(`^([A-Za-z_.$][\w.$]*):`)
+	jumpTableEntry = regexp.MustCompile(`^\.(byte|short|hword|2byte|long|word|4byte|quad|8byte)\s+\(?\.(LBB\w+)(?:-\.L\w+)?\)?(?:>>\d+)?$`)
+	pointerEntry   = regexp.MustCompile(`^\.(short|hword|2byte|long|int|word|4byte|quad|8byte|dword|xword)\s+\(?([A-Za-z_.$][\w.$]*)([+-](?:0x[0-9a-fA-F]+|\d+))?\)?(?:-([A-Za-z_.$][\w.$]*))?([+-](?:0x[0-9a-fA-F]+|\d+))?$`)
+)
+
+// entrySizes maps the directives of jump table entries and pointers to their
+// sizes.
+var entrySizes = map[string]int{
+	"byte":  1,
+	"short": 2,
+	"hword": 2,
+	"2byte": 2,
+	"long":  4,
+	"int":   4,
+	"word":  4,
+	"4byte": 4,
+	"quad":  8,
+	"8byte": 8,
+	"dword": 8,
+	"xword": 8,
+}
+
+// MaxDataAlignment is the largest alignment that the Go linker gives to data
+// symbols. Symbols without an explicit alignment, which includes every symbol
+// defined in Go assembly, are aligned to the largest power of two not above
+// their size, up to this bound.
+const MaxDataAlignment = 32
+
+// Section is the kind of section that assembly is emitted into.
+type Section int
+
+const (
+	TextSection Section = iota
+	// ReadOnlySection holds constants.
+	ReadOnlySection
+	// WritableSection holds initialized variables.
+	WritableSection
+	// ZeroSection holds zero-initialized variables, which take no space in
+	// the object file.
+	ZeroSection
+)
+
+// DataSection reports whether a line of assembly switches the current section,
+// and if so, the kind of the new section. Besides the ELF sections, the
+// mergeable and small data variants of which share prefixes, Mach-O literal,
+// constant and data sections are recognized.
+func DataSection(line string) (Section, bool) {
+	fields := strings.FieldsFunc(strings.TrimSpace(line), func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ','
+	})
+	if len(fields) == 0 {
+		return TextSection, false
+	}
+	switch fields[0] {
+	case ".text":
+		return TextSection, true
+	case ".data":
+		return WritableSection, true
+	case ".bss":
+		return ZeroSection, true
+	case ".const", ".const_data", ".cstring", ".literal4", ".literal8", ".literal16":
+		return ReadOnlySection, true
+	case ".section":
+		if len(fields) < 2 {
+			return TextSection, false
+		}
+		name := strings.Trim(fields[1], "\"")
+		var section string
+		if len(fields) > 2 {
+			section = fields[2]
+		}
+		switch name {
+		case "__TEXT":
+			if strings.HasPrefix(section, "__literal") || strings.HasPrefix(section, "__const") || section == "__cstring" {
+				return ReadOnlySection, true
+			}
+			return TextSection, true
+		case "__DATA_CONST":
+			return ReadOnlySection, true
+		case "__DATA":
+			switch section {
+			case "__const":
+				return ReadOnlySection, true
+			case "__bss", "__common":
+				return ZeroSection, true
+			}
+			return WritableSection, true
+		}
+		if strings.HasPrefix(name, ".data.rel.ro") {
+			return ReadOnlySection, true
+		}
+		for _, prefix := range []string{".rodata", ".srodata", ".lrodata", ".rdata"} {
+			if strings.HasPrefix(name, prefix) {
+				return ReadOnlySection, true
+			}
+		}
+		for _, prefix := range []string{".data", ".sdata", ".ldata"} {
+			if strings.HasPrefix(name, prefix) {
+				return WritableSection, true
+			}
+		}
+		for _, prefix := range []string{".bss", ".sbss", ".lbss"} {
+			if strings.HasPrefix(name, prefix) {
+				return ZeroSection, true
+			}
+		}
+		return TextSection, true
+	}
+	return TextSection, false
+}
+
+// ParseCommonSymbol parses a .comm or .lcomm directive, which defines a
+// zero-initialized symbol outside of any section.
+func ParseCommonSymbol(line string) (DataSymbol, bool, error) {
+	directive, operands, _ := strings.Cut(strings.TrimSpace(line), "\t")
+	if strings.ContainsRune(directive, ' ') {
+		directive, operands, _ = strings.Cut(strings.TrimSpace(line), " ")
+	}
+	if directive != ".comm" && directive != ".lcomm" {
+		return DataSymbol{}, false, nil
+	}
+	values := splitOperands(operands)
+	if len(values) < 2 {
+		return DataSymbol{}, false, fmt.Errorf("invalid common symbol: %s", line)
+	}
+	size, err := parseInteger(values[1])
+	if err != nil || size < 0 {
+		return DataSymbol{}, false, fmt.Errorf("unsupported size %q in %s", values[1], line)
+	}
+	symbol := DataSymbol{Name: DataSymbolName(values[0]), Data: make([]byte, size), Section: ZeroSection}
+	if len(values) > 2 {
+		if symbol.Alignment, err = parseInteger(values[2]); err != nil {
+			return DataSymbol{}, false, fmt.Errorf("unsupported alignment %q in %s", values[2], line)
+		}
+	}
+	return symbol, true, nil
+}
+
+// DataSymbolName returns the Go assembly name of a data label. Compiler-local
+// labels such as .LCPI0_0 or .L.str lose their leading dots, and characters
+// that are not allowed in Go assembly names are replaced with underscores.
+func DataSymbolName(name string) string {
+	name = strings.TrimLeft(name, ".")
+	return strings.Map(func(r rune) rune {
+		if r == '_' || ('0' <= r && r <= '9') || ('A' <= r && r <= 'Z') || ('a' <= r && r <= 'z') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// DataSymbolReference returns the Go assembly symbol that a data label refers
+// to. Data symbols are emitted with <> names, so they never clash with Go
+// symbols, except for exported globals, which are defined in the package.
+func DataSymbolReference(name string, symbols []DataSymbol) string {
+	name = DataSymbolName(name)
+	if slices.ContainsFunc(symbols, func(symbol DataSymbol) bool { return symbol.Name == name && symbol.Exported }) {
+		return "·" + name
+	}
+	return name + "<>"
+}
+
+// StripComment removes the comment that starts with marker from a line of
+// assembly. Markers in string literals, such as the operands of .ascii, do not
+// start comments.
+func StripComment(line, marker string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quoted && line[i] == '\\':
+			i++
+		case line[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(line[i:], marker):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// ParseDataLabel parses the name of a label in a data section.
+func ParseDataLabel(line string) (string, bool) {
+	matches := dataLabelLine.FindStringSubmatch(line)
+	if matches == nil {
+		return "", false
+	}
+	return matches[1], true
+}
+
+// ParseJumpTableEntry parses an entry of a jump table, which is the address of
+// a basic block label or its distance from a base label, and appends the
+// ordinal of the label to the data symbol.
+func ParseJumpTableEntry(line string, symbol *DataSymbol, byteOrder binary.ByteOrder) bool {
+	matches := jumpTableEntry.FindStringSubmatch(strings.TrimSpace(line))
+	if matches == nil {
+		return false
+	}
+	ordinal := slices.Index(symbol.Labels, matches[2])
+	if ordinal < 0 {
+		ordinal = len(symbol.Labels)
+		symbol.Labels = append(symbol.Labels, matches[2])
+	}
+	symbol.Data = appendInteger(symbol.Data, uint64(ordinal), entrySizes[matches[1]], byteOrder)
+	return true
+}
+
+// ParseDataPointer parses an entry of a pointer table, which is the address of
+// a symbol or its distance from a base label, and appends it to the pointers of
+// the data symbol. Only distances may be narrower than pointerSize bytes.
+func ParseDataPointer(line string, symbol *DataSymbol, pointerSize int) (bool, error) {
 	line = strings.TrimSpace(line)
-	if strings.HasPrefix(line, ".ascii") || strings.HasPrefix(line, ".asciz") {
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
+	matches := pointerEntry.FindStringSubmatch(line)
+	if matches == nil {
+		return false, nil
+	}
+	pointer := DataPointer{
+		Offset: len(symbol.Data),
+		Size:   entrySizes[matches[1]],
+		Symbol: DataSymbolName(matches[2]),
+	}
+	for _, value := range []string{matches[3], matches[5]} {
+		if value == "" {
+			continue
+		}
+		addend, err := parseInteger(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid addend in %s: %w", line, err)
+		}
+		pointer.Addend += addend
+	}
+	if matches[4] != "" {
+		pointer.Base = DataSymbolName(matches[4])
+	} else if pointer.Size != pointerSize {
+		return false, fmt.Errorf("unsupported %d-byte pointer in %s", pointer.Size, line)
+	}
+	symbol.Pointers = append(symbol.Pointers, pointer)
+	symbol.Data = append(symbol.Data, make([]byte, pointer.Size)...)
+	return true, nil
+}
+
+// ParseDataDirective parses a directive that emits data in assembly output, and
+// appends the bytes it emits to data, the contents of the current data symbol.
+// Integers are encoded in byteOrder, and alignment is relative to the start of
+// the symbol.
+func ParseDataDirective(line string, data []byte, byteOrder binary.ByteOrder) ([]byte, bool, error) {
+	line = strings.TrimSpace(line)
+	directive, operands, _ := strings.Cut(line, "\t")
+	if strings.ContainsRune(directive, ' ') {
+		directive, operands, _ = strings.Cut(line, " ")
+	}
+	switch directive {
+	case ".ascii", ".asciz", ".string":
+		value := strings.TrimSpace(operands)
+		if value == "" {
 			return nil, false, fmt.Errorf("invalid ascii directive: %s", line)
 		}
-		value := strings.TrimSpace(strings.TrimPrefix(line, parts[0]))
 		decoded, err := strconv.Unquote(value)
 		if err != nil {
 			return nil, false, err
 		}
-		data := []byte(decoded)
-		if strings.HasPrefix(line, ".asciz") {
+		data = append(data, decoded...)
+		if directive != ".ascii" {
 			data = append(data, 0)
 		}
 		return data, true, nil
 	}
+
+	values := splitOperands(operands)
+	switch directive {
+	case ".byte":
+		return appendIntegers(data, values, 1, byteOrder, line)
+	case ".short", ".hword", ".value", ".2byte", ".half":
+		return appendIntegers(data, values, 2, byteOrder, line)
+	case ".long", ".int", ".word", ".4byte":
+		// .word is 4 bytes on every target that clang emits it for.
+		return appendIntegers(data, values, 4, byteOrder, line)
+	case ".quad", ".8byte", ".dword", ".xword":
+		return appendIntegers(data, values, 8, byteOrder, line)
+	case ".float", ".single":
+		for _, value := range values {
+			f, err := strconv.ParseFloat(value, 32)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid float in %s: %w", line, err)
+			}
+			data = appendInteger(data, uint64(math.Float32bits(float32(f))), 4, byteOrder)
+		}
+		return data, true, nil
+	case ".double":
+		for _, value := range values {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid double in %s: %w", line, err)
+			}
+			data = appendInteger(data, math.Float64bits(f), 8, byteOrder)
+		}
+		return data, true, nil
+	case ".zero", ".space", ".skip":
+		if len(values) == 0 || len(values) > 2 {
+			return nil, false, fmt.Errorf("invalid space directive: %s", line)
+		}
+		size, err := parseInteger(values[0])
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid size in %s: %w", line, err)
+		}
+		var fill int64
+		if len(values) == 2 {
+			if fill, err = parseInteger(values[1]); err != nil {
+				return nil, false, fmt.Errorf("invalid fill in %s: %w", line, err)
+			}
+		}
+		for i := int64(0); i < size; i++ {
+			data = append(data, byte(fill))
+		}
+		return data, true, nil
+	case ".fill":
+		if len(values) == 0 || len(values) > 3 {
+			return nil, false, fmt.Errorf("invalid fill directive: %s", line)
+		}
+		numbers := []int64{0, 1, 0}
+		for i, value := range values {
+			number, err := parseInteger(value)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid operand in %s: %w", line, err)
+			}
+			numbers[i] = number
+		}
+		if numbers[1] < 0 || numbers[1] > 8 {
+			return nil, false, fmt.Errorf("invalid fill size in %s", line)
+		}
+		for i := int64(0); i < numbers[0]; i++ {
+			data = appendInteger(data, uint64(numbers[2]), int(numbers[1]), byteOrder)
+		}
+		return data, true, nil
+	case ".p2align", ".balign", ".align":
+		alignment, fill, limit, err := parseAlignment(directive, values, line)
+		if err != nil {
+			return nil, false, err
+		}
+		padding := (alignment - int64(len(data))%alignment) % alignment
+		if padding <= limit {
+			for i := int64(0); i < padding; i++ {
+				data = append(data, byte(fill))
+			}
+		}
+		return data, true, nil
+	case ".size":
+		// Pad the symbol to its declared size. Sizes given as expressions are
+		// already covered by the data emitted before them.
+		if len(values) != 2 {
+			return nil, false, fmt.Errorf("invalid size directive: %s", line)
+		}
+		if size, err := parseInteger(values[1]); err == nil {
+			for int64(len(data)) < size {
+				data = append(data, 0)
+			}
+		}
+		return data, true, nil
+	}
 	return nil, false, nil
 }
 
+// ParseAlignment parses an alignment directive, which aligns the data symbol
+// that follows it, and returns the alignment in bytes.
+func ParseAlignment(line string) (int64, bool) {
+	line = strings.TrimSpace(line)
+	directive, operands, _ := strings.Cut(line, "\t")
+	if strings.ContainsRune(directive, ' ') {
+		directive, operands, _ = strings.Cut(line, " ")
+	}
+	if directive != ".p2align" && directive != ".balign" && directive != ".align" {
+		return 0, false
+	}
+	alignment, _, _, err := parseAlignment(directive, splitOperands(operands), line)
+	return alignment, err == nil
+}
+
+// parseAlignment parses the operands of an alignment directive, which are the
+// alignment, the fill value and the maximum number of bytes to skip.
+func parseAlignment(directive string, values []string, line string) (alignment, fill, limit int64, err error) {
+	// .align takes a power of two on the ELF targets other than x86, which
+	// is the only place it is emitted by the supported compilers.
+	if len(values) == 0 {
+		return 0, 0, 0, fmt.Errorf("invalid align directive: %s", line)
+	}
+	numbers := []int64{0, 0, math.MaxInt64}
+	for i, value := range values {
+		if i >= len(numbers) {
+			break
+		}
+		if value == "" {
+			continue
+		}
+		number, err := parseInteger(value)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid operand in %s: %w", line, err)
+		}
+		numbers[i] = number
+	}
+	alignment = numbers[0]
+	if directive != ".balign" {
+		alignment = 1 << numbers[0]
+	}
+	if alignment <= 0 {
+		return 0, 0, 0, fmt.Errorf("invalid alignment in %s", line)
+	}
+	return alignment, numbers[1], numbers[2], nil
+}
+
+// splitOperands splits the comma-separated operands of a directive, dropping
+// trailing comments.
+func splitOperands(operands string) []string {
+	for _, comment := range []string{"//", "#", ";"} {
+		operands, _, _ = strings.Cut(operands, comment)
+	}
+	operands = strings.TrimSpace(operands)
+	if operands == "" {
+		return nil
+	}
+	values := strings.Split(operands, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+// parseInteger parses an integer in the C syntax accepted by assemblers. Values
+// beyond the range of int64 are wrapped.
+func parseInteger(value string) (int64, error) {
+	number, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		unsigned, unsignedErr := strconv.ParseUint(value, 0, 64)
+		if unsignedErr != nil {
+			return 0, err
+		}
+		return int64(unsigned), nil
+	}
+	return number, nil
+}
+
+func appendIntegers(data []byte, values []string, size int, byteOrder binary.ByteOrder, line string) ([]byte, bool, error) {
+	if len(values) == 0 {
+		return nil, false, fmt.Errorf("missing value in %s", line)
+	}
+	for _, value := range values {
+		number, err := parseInteger(value)
+		if err != nil {
+			return nil, false, fmt.Errorf("unsupported value %q in %s", value, line)
+		}
+		data = appendInteger(data, uint64(number), size, byteOrder)
+	}
+	return data, true, nil
+}
+
+// appendInteger appends the low size bytes of value in byteOrder.
+func appendInteger(data []byte, value uint64, size int, byteOrder binary.ByteOrder) []byte {
+	for i := 0; i < size; i++ {
+		shift := 8 * i
+		if byteOrder == binary.BigEndian {
+			shift = 8 * (size - 1 - i)
+		}
+		data = append(data, byte(value>>shift))
+	}
+	return data
+}
+
+// DataCollector collects the data symbols defined in assembly output, which is
+// fed to it line by line.
+type DataCollector struct {
+	// JumpTables enables jump table entries, for the targets that rewrite the
+	// dispatch of jump tables.
+	JumpTables bool
+	// Globals are the C globals exported as Go variables, the symbols of
+	// which are marked exported.
+	Globals   []Global
+	Symbols   []DataSymbol
+	section   Section
+	name      string
+	alignment int64
+}
+
+// Collect parses a line of assembly output that defines data, and reports
+// whether the line is consumed. Pointers are ptrSize bytes, and integers are
+// encoded in order.
+func (c *DataCollector) Collect(line string, ptrSize int, order binary.ByteOrder) (bool, error) {
+	if section, switched := DataSection(line); switched {
+		c.section = section
+		c.name = ""
+		c.alignment = 0
+	}
+	if alignment, ok := ParseAlignment(line); ok {
+		c.alignment = alignment
+	}
+	if common, ok, err := ParseCommonSymbol(line); err != nil {
+		return false, err
+	} else if ok {
+		common.Exported = c.exported(common.Name)
+		c.Symbols = append(c.Symbols, common)
+		return true, nil
+	}
+	if c.section != TextSection {
+		if name, ok := ParseDataLabel(line); ok {
+			c.Define(name, c.section)
+			return true, nil
+		}
+	}
+	if c.name == "" {
+		return false, nil
+	}
+	symbol := &c.Symbols[len(c.Symbols)-1]
+	if c.JumpTables && ParseJumpTableEntry(line, symbol, order) {
+		return true, nil
+	}
+	if ok, err := ParseDataPointer(line, symbol, ptrSize); err != nil || ok {
+		return ok, err
+	}
+	parsed, ok, err := ParseDataDirective(line, symbol.Data, order)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		symbol.Data = parsed
+	}
+	return ok, nil
+}
+
+// Define starts a data symbol at a label in a section, which is aligned by the
+// alignment directive before it.
+func (c *DataCollector) Define(label string, section Section) {
+	c.name = DataSymbolName(label)
+	c.Symbols = append(c.Symbols, DataSymbol{Name: c.name, Section: section, Alignment: c.alignment, Exported: c.exported(c.name)})
+	c.alignment = 0
+}
+
+// exported reports whether a data symbol is of an exported global.
+func (c *DataCollector) exported(name string) bool {
+	return slices.ContainsFunc(c.Globals, func(global Global) bool { return DataSymbolName(global.Name) == name })
+}
+
+// End ends the current data symbol, so the lines that follow are not data.
+func (c *DataCollector) End() {
+	c.name = ""
+}
+
+// Name returns the name of the current data symbol, or an empty string if
+// there is none.
+func (c *DataCollector) Name() string {
+	return c.name
+}
+
+// Section returns the kind of the current section.
+func (c *DataCollector) Section() Section {
+	return c.section
+}
+
+// JumpTable returns the jump table that an indirect branch at index dispatches
+// through, which is the last one whose address is loaded by a line matching re
+// before the branch. The first submatch of re is the label of the table.
+func JumpTable(lines []Line, index int, re *regexp.Regexp, symbols []DataSymbol) (DataSymbol, bool) {
+	for i := index; i >= 0; i-- {
+		if matches := re.FindStringSubmatch(lines[i].Assembly); matches != nil {
+			name := DataSymbolName(matches[1])
+			for _, symbol := range symbols {
+				if symbol.Name == name && len(symbol.Labels) > 0 {
+					return symbol, true
+				}
+			}
+			return DataSymbol{}, false
+		}
+	}
+	return DataSymbol{}, false
+}
+
+// CheckDataAlignment returns an error for the first data symbol aligned above
+// MaxDataAlignment, which the Go linker would not align, for the targets that
+// cannot work around it in code.
+func CheckDataAlignment(symbols []DataSymbol) error {
+	for _, symbol := range symbols {
+		if symbol.Alignment > MaxDataAlignment {
+			return fmt.Errorf("data symbol %s: unsupported alignment of %d bytes, the maximum is %d",
+				symbol.Name, symbol.Alignment, MaxDataAlignment)
+		}
+	}
+	return nil
+}
+
 // GenerateDataSymbols emits Go asm DATA/GLOBL directives for data symbols.
-func GenerateDataSymbols(symbols []DataSymbol, byteOrder binary.ByteOrder) string {
+// Zero-initialized symbols are left to the linker, so they have no DATA. Since
+// Go assembly cannot declare the alignment of a symbol, symbols are padded to
+// at least their alignment, which the linker aligns them to. Pointers are
+// emitted as addresses resolved by the linker.
+func GenerateDataSymbols(symbols []DataSymbol, byteOrder binary.ByteOrder) (string, error) {
 	var builder strings.Builder
 	for _, symbol := range symbols {
-		for offset := 0; offset < len(symbol.Data); {
+		symbol, err := resolveDistances(symbol, symbols, byteOrder)
+		if err != nil {
+			return "", err
+		}
+		pointers := make(map[int]DataPointer)
+		for _, pointer := range symbol.Pointers {
+			if pointer.Base == "" {
+				pointers[pointer.Offset] = pointer
+			}
+		}
+		size := max(int64(len(symbol.Data)), min(symbol.Alignment, MaxDataAlignment))
+		for offset := 0; symbol.Section != ZeroSection && offset < len(symbol.Data); {
+			if pointer, ok := pointers[offset]; ok {
+				var addend string
+				if pointer.Addend != 0 {
+					addend = fmt.Sprintf("%+d", pointer.Addend)
+				}
+				builder.WriteString(fmt.Sprintf("DATA %s+0x%03x(SB)/%d, $%s%s(SB)\n", DataSymbolReference(symbol.Name, symbols), offset, pointer.Size, DataSymbolReference(pointer.Symbol, symbols), addend))
+				offset += pointer.Size
+				continue
+			}
 			remaining := len(symbol.Data) - offset
 			size := min(remaining, 8)
+			for next := offset + 1; next < offset+size; next++ {
+				if _, ok := pointers[next]; ok {
+					size = next - offset
+					break
+				}
+			}
+			// DATA takes integers of 1, 2, 4 or 8 bytes.
+			for size&(size-1) != 0 {
+				size &= size - 1
+			}
 			var value uint64
 			for i := 0; i < size; i++ {
 				if byteOrder == binary.BigEndian {
@@ -65,10 +688,56 @@ func GenerateDataSymbols(symbols []DataSymbol, byteOrder binary.ByteOrder) strin
 					value |= uint64(symbol.Data[offset+i]) << (8 * i)
 				}
 			}
-			builder.WriteString(fmt.Sprintf("DATA %s<>+0x%03x(SB)/%d, $0x%0*x\n", symbol.Name, offset, size, size*2, value))
+			builder.WriteString(fmt.Sprintf("DATA %s+0x%03x(SB)/%d, $0x%0*x\n", DataSymbolReference(symbol.Name, symbols), offset, size, size*2, value))
 			offset += size
 		}
-		builder.WriteString(fmt.Sprintf("GLOBL %s<>(SB), 8, $%d\n\n", symbol.Name, len(symbol.Data)))
+		flags := NOPTR
+		if symbol.Section == ReadOnlySection {
+			flags |= RODATA
+		}
+		builder.WriteString(fmt.Sprintf("GLOBL %s(SB), %d, $%d\n\n", DataSymbolReference(symbol.Name, symbols), flags, size))
+	}
+	return builder.String(), nil
+}
+
+// resolveDistances fills in the distances of the pointers of a data symbol,
+// which the linker cannot compute between Go symbols. The distances must be
+// from the start of the symbol, and a read-only symbol they point to is copied
+// to the end of the data, so it is at a fixed distance.
+func resolveDistances(symbol DataSymbol, symbols []DataSymbol, byteOrder binary.ByteOrder) (DataSymbol, error) {
+	symbol.Data = slices.Clone(symbol.Data)
+	copies := map[string]int{symbol.Name: 0}
+	for _, pointer := range symbol.Pointers {
+		if pointer.Base == "" {
+			continue
+		}
+		if pointer.Base != symbol.Name {
+			return DataSymbol{}, fmt.Errorf("data symbol %s: unsupported distance from %s", symbol.Name, pointer.Base)
+		}
+		offset, ok := copies[pointer.Symbol]
+		if !ok {
+			index := slices.IndexFunc(symbols, func(target DataSymbol) bool {
+				return target.Name == pointer.Symbol
+			})
+			if index < 0 || symbols[index].Section != ReadOnlySection || len(symbols[index].Pointers) > 0 {
+				return DataSymbol{}, fmt.Errorf("data symbol %s: unsupported distance to %s", symbol.Name, pointer.Symbol)
+			}
+			target := symbols[index]
+			alignment := max(min(target.Alignment, MaxDataAlignment), 1)
+			for int64(len(symbol.Data))%alignment != 0 {
+				symbol.Data = append(symbol.Data, 0)
+			}
+			symbol.Alignment = max(symbol.Alignment, alignment)
+			offset = len(symbol.Data)
+			copies[pointer.Symbol] = offset
+			symbol.Data = append(symbol.Data, target.Data...)
+		}
+		distance := int64(offset) + pointer.Addend
+		if bits := 8 * pointer.Size; bits < 64 && (distance < -1<<(bits-1) || distance >= 1<<(bits-1)) {
+			return DataSymbol{}, fmt.Errorf("data symbol %s: distance to %s overflows %d bytes", symbol.Name, pointer.Symbol, pointer.Size)
+		}
+		encoded := appendInteger(nil, uint64(distance), pointer.Size, byteOrder)
+		copy(symbol.Data[pointer.Offset:], encoded)
 	}
-	return builder.String()
+	return symbol, nil
 }
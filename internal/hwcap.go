@@ -0,0 +1,100 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Types of the entries of the Linux auxiliary vector that hold hardware
+// capabilities.
+const (
+	AtHWCap  = 16
+	AtHWCap2 = 26
+)
+
+// HWCap is a CPU feature that clang reports by a predefined macro and Linux by
+// a bit of the auxiliary vector.
+type HWCap struct {
+	Name  string
+	Macro string
+	Entry int
+	Bit   int
+}
+
+// EnabledHWCaps returns the hardware capabilities of the target that the source
+// is compiled for, according to the macros predefined by clang.
+func (t *TranslateUnit) EnabledHWCaps() ([]HWCap, error) {
+	if len(t.Target.HWCaps) == 0 {
+		return nil, nil
+	}
+	args := []string{"-target", t.Target.ClangTriple}
+	args = append(args, t.Target.ClangOptions...)
+	args = append(args, t.Options...)
+	args = append(args, "-dM", "-E", "-x", "c", os.DevNull)
+	output, err := RunCommand(GetClangPath(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch predefined macros: %w", err)
+	}
+	macros := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "#define" {
+			macros[fields[1]] = true
+		}
+	}
+	var enabled []HWCap
+	for _, hwcap := range t.Target.HWCaps {
+		if macros[hwcap.Macro] {
+			enabled = append(enabled, hwcap)
+		}
+	}
+	return enabled, nil
+}
+
+// GenerateSupported returns a Go function that reports whether the CPU has the
+// hardware capabilities, read from the auxiliary vector of a 64-bit Linux.
+func GenerateSupported(hwcaps []HWCap) string {
+	var builder strings.Builder
+	names := make([]string, 0, len(hwcaps))
+	for _, hwcap := range hwcaps {
+		names = append(names, hwcap.Name)
+	}
+	list := names[len(names)-1]
+	if len(names) > 1 {
+		list = strings.Join(names[:len(names)-1], ", ") + " and " + list
+	}
+	builder.WriteString(fmt.Sprintf("\n// Supported reports whether the CPU supports %s, which the functions of\n", list))
+	builder.WriteString("// this file are compiled for.\n")
+	builder.WriteString("func Supported() bool {\n")
+	builder.WriteString("\tauxv, err := os.ReadFile(\"/proc/self/auxv\")\n")
+	builder.WriteString("\tif err != nil {\n")
+	builder.WriteString("\t\treturn false\n")
+	builder.WriteString("\t}\n")
+	builder.WriteString("\thwcaps := make(map[uint64]uint64)\n")
+	builder.WriteString("\tfor i := 0; i+16 <= len(auxv); i += 16 {\n")
+	builder.WriteString("\t\thwcaps[binary.NativeEndian.Uint64(auxv[i:])] = binary.NativeEndian.Uint64(auxv[i+8:])\n")
+	builder.WriteString("\t}\n")
+	for i, hwcap := range hwcaps {
+		if i == 0 {
+			builder.WriteString("\treturn ")
+		} else {
+			builder.WriteString(" &&\n\t\t")
+		}
+		builder.WriteString(fmt.Sprintf("hwcaps[%d]&(1<<%d) != 0", hwcap.Entry, hwcap.Bit))
+	}
+	builder.WriteString("\n}\n")
+	return builder.String()
+}
@@ -0,0 +1,160 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package internal
+
+import (
+	"debug/elf"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+//go:embed libc/*.c
+var libc embed.FS
+
+// libcFunctions maps the library functions bundled with GoAT to the sources
+// implementing them: the memory routines that compilers emit calls to, even for
+// freestanding code, and a subset of libm. Each function is guarded by a
+// GOAT_LIBC_<name> macro in its source.
+var libcFunctions = map[string]string{
+	"memcpy":  "libc/memory.c",
+	"memmove": "libc/memory.c",
+	"memset":  "libc/memory.c",
+	"bzero":   "libc/memory.c",
+	"__bzero": "libc/memory.c",
+
+	"fabs":  "libc/math.c",
+	"fabsf": "libc/math.c",
+	"frexp": "libc/math.c",
+	"ldexp": "libc/math.c",
+	"sqrt":  "libc/math.c",
+	"sqrtf": "libc/math.c",
+	"exp":   "libc/math.c",
+	"expf":  "libc/math.c",
+	"log":   "libc/math.c",
+	"logf":  "libc/math.c",
+	"sin":   "libc/math.c",
+	"sinf":  "libc/math.c",
+	"cos":   "libc/math.c",
+	"cosf":  "libc/math.c",
+	"tanh":  "libc/math.c",
+	"tanhf": "libc/math.c",
+	"erf":   "libc/math.c",
+	"erff":  "libc/math.c",
+}
+
+// UndefinedSymbols returns the names of undefined symbols in an ELF object.
+func UndefinedSymbols(path string) ([]string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	symbols, err := f.Symbols()
+	if errors.Is(err, elf.ErrNoSymbols) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, symbol := range symbols {
+		if symbol.Section == elf.SHN_UNDEF && symbol.Name != "" {
+			names = append(names, symbol.Name)
+		}
+	}
+	return names, nil
+}
+
+// linkLibc recompiles the source with the bundled library functions referenced
+// by its object file, until no more function is needed. Only the referenced
+// functions are defined, so that the source may define others itself. It
+// reports the library functions that were linked and those that remain
+// unresolved, and returns the names of library functions compiled into the
+// object.
+func (t *TranslateUnit) linkLibc() ([]string, error) {
+	var dir string
+	defer func() {
+		if dir != "" {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+	included := make(map[string]bool)
+	defines := make(map[string][]string)
+	var linked, unresolved []string
+	for {
+		undefined, err := UndefinedSymbols(t.Object)
+		if err != nil {
+			return nil, err
+		}
+		var sources []string
+		unresolved = unresolved[:0]
+		for _, name := range undefined {
+			if source, ok := libcFunctions[name]; ok {
+				if !slices.Contains(sources, source) {
+					sources = append(sources, source)
+				}
+				defines[source] = append(defines[source], name)
+				linked = append(linked, name)
+			} else if !strings.HasPrefix(name, ".") && !strings.HasPrefix(name, "_GLOBAL_OFFSET_TABLE_") {
+				unresolved = append(unresolved, name)
+			}
+		}
+		if len(sources) == 0 {
+			break
+		}
+		if dir == "" {
+			if dir, err = os.MkdirTemp("", "goat"); err != nil {
+				return nil, err
+			}
+		}
+		for _, source := range sources {
+			data, err := libc.ReadFile(source)
+			if err != nil {
+				return nil, err
+			}
+			var builder strings.Builder
+			for _, name := range defines[source] {
+				builder.WriteString(fmt.Sprintf("#define GOAT_LIBC_%s\n", name))
+			}
+			builder.Write(data)
+			path := filepath.Join(dir, filepath.Base(source))
+			if err = os.WriteFile(path, []byte(builder.String()), 0644); err != nil {
+				return nil, err
+			}
+			if !included[source] {
+				included[source] = true
+				t.Includes = append(t.Includes, path)
+			}
+		}
+		if err = t.compile(t.Options...); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(linked) > 0 {
+		sort.Strings(linked)
+		_, _ = fmt.Fprintln(os.Stderr, "linked library functions:", strings.Join(linked, ", "))
+	}
+	if len(unresolved) > 0 {
+		sort.Strings(unresolved)
+		_, _ = fmt.Fprintln(os.Stderr, "unresolved library functions:", strings.Join(unresolved, ", "))
+	}
+
+	return linked, nil
+}
@@ -0,0 +1,557 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Freestanding implementations of common math library functions, ported from
+// the math package of Go (BSD license), which in turn derives them from FreeBSD
+// and Cephes. GoAT includes this file into translation units whose object
+// files reference these functions, defining GOAT_LIBC_<name> for each of them
+// so that the functions defined by the source are not redefined. Single
+// precision functions are computed in double precision.
+//
+// GoAT compiles with -fno-builtin, so calls between these functions are not
+// rewritten into calls to other library functions.
+
+union goat_double
+{
+    double f;
+    unsigned long long u;
+};
+
+double fabs(double x);
+double frexp(double x, int *exp);
+double ldexp(double frac, int exp);
+double sqrt(double x);
+double exp(double x);
+double log(double x);
+double sin(double x);
+double cos(double x);
+double tanh(double x);
+double erf(double x);
+
+#ifdef GOAT_LIBC_fabs
+double fabs(double x)
+{
+    union goat_double v = {x};
+    v.u &= ~(1ULL << 63);
+    return v.f;
+}
+#endif
+
+#ifdef GOAT_LIBC_fabsf
+float fabsf(float x)
+{
+    return (float)fabs(x);
+}
+#endif
+
+#ifdef GOAT_LIBC_frexp
+double frexp(double x, int *exp)
+{
+    *exp = 0;
+    if (x == 0 || x != x || x - x != 0)
+    {
+        return x;
+    }
+    if (fabs(x) < 2.2250738585072014e-308)
+    {
+        // normalize subnormal x
+        x *= 4503599627370496.0; // 2**52
+        *exp = -52;
+    }
+    union goat_double v = {x};
+    *exp += (int)((v.u >> 52) & 0x7ff) - 1022;
+    v.u &= ~(0x7ffULL << 52);
+    v.u |= 1022ULL << 52;
+    return v.f;
+}
+#endif
+
+#ifdef GOAT_LIBC_ldexp
+double ldexp(double frac, int exp)
+{
+    if (frac == 0 || frac != frac || frac - frac != 0)
+    {
+        return frac;
+    }
+    int e;
+    frac = frexp(frac, &e);
+    exp += e;
+    if (exp < -1074)
+    {
+        return frac < 0 ? -0.0 : 0.0; // underflow
+    }
+    if (exp > 1024)
+    {
+        return frac < 0 ? -__builtin_inf() : __builtin_inf(); // overflow
+    }
+    double m = 1;
+    if (exp < -1021)
+    {
+        // denormal
+        exp += 53;
+        m = 1.0 / 9007199254740992.0; // 2**-53
+    }
+    union goat_double v = {frac};
+    v.u &= ~(0x7ffULL << 52);
+    v.u |= (unsigned long long)(exp - 1 + 1023) << 52;
+    return m * v.f;
+}
+#endif
+
+#ifdef GOAT_LIBC_sqrt
+double sqrt(double x)
+{
+    if (x == 0 || x != x || x == __builtin_inf())
+    {
+        return x;
+    }
+    if (x < 0)
+    {
+        return __builtin_nan("");
+    }
+    union goat_double v = {x};
+    unsigned long long ix = v.u;
+    // normalize x
+    int exp = (int)((ix >> 52) & 0x7ff);
+    if (exp == 0)
+    {
+        // subnormal x
+        while ((ix & (1ULL << 52)) == 0)
+        {
+            ix <<= 1;
+            exp--;
+        }
+        exp++;
+    }
+    exp -= 1023;
+    ix &= ~(0x7ffULL << 52);
+    ix |= 1ULL << 52;
+    if (exp & 1)
+    {
+        // odd exp, double x to make it even
+        ix <<= 1;
+    }
+    exp >>= 1;
+    // generate sqrt(x) bit by bit
+    ix <<= 1;
+    unsigned long long q = 0, s = 0, r = 1ULL << 53;
+    while (r != 0)
+    {
+        unsigned long long t = s + r;
+        if (t <= ix)
+        {
+            s = t + r;
+            ix -= t;
+            q += r;
+        }
+        ix <<= 1;
+        r >>= 1;
+    }
+    // final rounding
+    if (ix != 0)
+    {
+        q += q & 1;
+    }
+    v.u = (q >> 1) + ((unsigned long long)(exp - 1 + 1023) << 52);
+    return v.f;
+}
+#endif
+
+#ifdef GOAT_LIBC_sqrtf
+float sqrtf(float x)
+{
+    return (float)sqrt(x);
+}
+#endif
+
+#ifdef GOAT_LIBC_exp
+double exp(double x)
+{
+    const double Ln2Hi = 6.93147180369123816490e-01;
+    const double Ln2Lo = 1.90821492927058770002e-10;
+    const double Log2e = 1.44269504088896338700e+00;
+    const double Overflow = 7.09782712893383973096e+02;
+    const double Underflow = -7.45133219101941108420e+02;
+    const double NearZero = 1.0 / (1 << 28);
+    const double P1 = 1.66666666666666657415e-01;
+    const double P2 = -2.77777777770155933842e-03;
+    const double P3 = 6.61375632143793436117e-05;
+    const double P4 = -1.65339022054652515390e-06;
+    const double P5 = 4.13813679705723846039e-08;
+
+    if (x != x || x == __builtin_inf())
+    {
+        return x;
+    }
+    if (x > Overflow)
+    {
+        return __builtin_inf();
+    }
+    if (x < Underflow)
+    {
+        return 0;
+    }
+    if (-NearZero < x && x < NearZero)
+    {
+        return 1 + x;
+    }
+    // reduce; computed as r = hi - lo for extra precision
+    int k = 0;
+    if (x < 0)
+    {
+        k = (int)(Log2e * x - 0.5);
+    }
+    else if (x > 0)
+    {
+        k = (int)(Log2e * x + 0.5);
+    }
+    double hi = x - k * Ln2Hi;
+    double lo = k * Ln2Lo;
+    // compute
+    double r = hi - lo;
+    double t = r * r;
+    double c = r - t * (P1 + t * (P2 + t * (P3 + t * (P4 + t * P5))));
+    double y = 1 - ((lo - (r * c) / (2 - c)) - hi);
+    return ldexp(y, k);
+}
+#endif
+
+#ifdef GOAT_LIBC_expf
+float expf(float x)
+{
+    return (float)exp(x);
+}
+#endif
+
+#ifdef GOAT_LIBC_log
+double log(double x)
+{
+    const double Ln2Hi = 6.93147180369123816490e-01;
+    const double Ln2Lo = 1.90821492927058770002e-10;
+    const double L1 = 6.666666666666735130e-01;
+    const double L2 = 3.999999999940941908e-01;
+    const double L3 = 2.857142874366239149e-01;
+    const double L4 = 2.222219843214978396e-01;
+    const double L5 = 1.818357216161805012e-01;
+    const double L6 = 1.531383769920937332e-01;
+    const double L7 = 1.479819860511658591e-01;
+
+    if (x != x || x == __builtin_inf())
+    {
+        return x;
+    }
+    if (x < 0)
+    {
+        return __builtin_nan("");
+    }
+    if (x == 0)
+    {
+        return -__builtin_inf();
+    }
+    // reduce
+    int ki;
+    double f1 = frexp(x, &ki);
+    if (f1 < 0.70710678118654752440)
+    {
+        f1 *= 2;
+        ki--;
+    }
+    double f = f1 - 1;
+    double k = ki;
+    // compute
+    double s = f / (2 + f);
+    double s2 = s * s;
+    double s4 = s2 * s2;
+    double t1 = s2 * (L1 + s4 * (L3 + s4 * (L5 + s4 * L7)));
+    double t2 = s4 * (L2 + s4 * (L4 + s4 * L6));
+    double R = t1 + t2;
+    double hfsq = 0.5 * f * f;
+    return k * Ln2Hi - ((hfsq - (s * (hfsq + R) + k * Ln2Lo)) - f);
+}
+#endif
+
+#ifdef GOAT_LIBC_logf
+float logf(float x)
+{
+    return (float)log(x);
+}
+#endif
+
+#if defined(GOAT_LIBC_sin) || defined(GOAT_LIBC_cos)
+// goat_trig computes sin(x + quadrant*Pi/2) for x >= 0.
+// Arguments of 2**29 and above lose precision, since they are reduced without
+// the Payne-Hanek algorithm; those of 2**63 and above return NaN.
+static inline __attribute__((always_inline)) double goat_trig(double x, unsigned int quadrant)
+{
+    const double PI4A = 7.85398125648498535156e-1;
+    const double PI4B = 3.77489470793079817668e-8;
+    const double PI4C = 2.69515142907905952645e-15;
+    const double S0 = 1.58962301576546568060e-10;
+    const double S1 = -2.50507477628578072866e-8;
+    const double S2 = 2.75573136213857245213e-6;
+    const double S3 = -1.98412698295895385996e-4;
+    const double S4 = 8.33333333332211858878e-3;
+    const double S5 = -1.66666666666666307295e-1;
+    const double C0 = -1.13585365213876817300e-11;
+    const double C1 = 2.08757008419747316778e-9;
+    const double C2 = -2.75573141792967388112e-7;
+    const double C3 = 2.48015872888517045348e-5;
+    const double C4 = -1.38888888888730564116e-3;
+    const double C5 = 4.16666666666665929218e-2;
+
+    if (x != x || x >= 9223372036854775808.0)
+    {
+        return __builtin_nan("");
+    }
+    // integer part of x/(Pi/4)
+    unsigned long long j = (unsigned long long)(x * 1.27323954473516268615);
+    double y = (double)j;
+    // map zeros to origin
+    if (j & 1)
+    {
+        j++;
+        y++;
+    }
+    j = (j + quadrant * 2) & 7;
+    double z = ((x - y * PI4A) - y * PI4B) - y * PI4C;
+    // reflect in x axis
+    int sign = 0;
+    if (j > 3)
+    {
+        sign = 1;
+        j -= 4;
+    }
+    double zz = z * z;
+    if (j == 1 || j == 2)
+    {
+        y = 1.0 - 0.5 * zz + zz * zz * (((((C0 * zz + C1) * zz + C2) * zz + C3) * zz + C4) * zz + C5);
+    }
+    else
+    {
+        y = z + z * zz * (((((S0 * zz + S1) * zz + S2) * zz + S3) * zz + S4) * zz + S5);
+    }
+    return sign ? -y : y;
+}
+#endif
+
+#ifdef GOAT_LIBC_sin
+double sin(double x)
+{
+    if (x == 0)
+    {
+        return x;
+    }
+    if (x < 0)
+    {
+        return -goat_trig(-x, 0);
+    }
+    return goat_trig(x, 0);
+}
+#endif
+
+#ifdef GOAT_LIBC_sinf
+float sinf(float x)
+{
+    return (float)sin(x);
+}
+#endif
+
+#ifdef GOAT_LIBC_cos
+double cos(double x)
+{
+    return goat_trig(fabs(x), 1);
+}
+#endif
+
+#ifdef GOAT_LIBC_cosf
+float cosf(float x)
+{
+    return (float)cos(x);
+}
+#endif
+
+#ifdef GOAT_LIBC_tanh
+double tanh(double x)
+{
+    const double MAXLOG = 8.8029691931113054295988e+01;
+    const double P0 = -9.64399179425052238628e-1;
+    const double P1 = -9.92877231001918586564e1;
+    const double P2 = -1.61468768441708447952e3;
+    const double Q0 = 1.12811678491632931402e2;
+    const double Q1 = 2.23548839060100448583e3;
+    const double Q2 = 4.84406305325125486048e3;
+
+    double z = fabs(x);
+    if (z > 0.5 * MAXLOG)
+    {
+        return x < 0 ? -1 : 1;
+    }
+    if (z >= 0.625)
+    {
+        double s = exp(2 * z);
+        z = 1 - 2 / (s + 1);
+        return x < 0 ? -z : z;
+    }
+    if (x == 0 || x != x)
+    {
+        return x;
+    }
+    double s = x * x;
+    return x + x * s * ((P0 * s + P1) * s + P2) / (((s + Q0) * s + Q1) * s + Q2);
+}
+#endif
+
+#ifdef GOAT_LIBC_tanhf
+float tanhf(float x)
+{
+    return (float)tanh(x);
+}
+#endif
+
+#ifdef GOAT_LIBC_erf
+double erf(double x)
+{
+    const double VeryTiny = 2.848094538889218e-306;
+    const double Small = 1.0 / (1 << 28);
+    const double erx = 8.45062911510467529297e-01;
+    // Coefficients for approximation to erf in [0, 0.84375]
+    const double efx = 1.28379167095512586316e-01;
+    const double efx8 = 1.02703333676410069053e+00;
+    const double pp0 = 1.28379167095512558561e-01;
+    const double pp1 = -3.25042107247001499370e-01;
+    const double pp2 = -2.84817495755985104766e-02;
+    const double pp3 = -5.77027029648944159157e-03;
+    const double pp4 = -2.37630166566501626084e-05;
+    const double qq1 = 3.97917223959155352819e-01;
+    const double qq2 = 6.50222499887672944485e-02;
+    const double qq3 = 5.08130628187576562776e-03;
+    const double qq4 = 1.32494738004321644526e-04;
+    const double qq5 = -3.96022827877536812320e-06;
+    // Coefficients for approximation to erf in [0.84375, 1.25]
+    const double pa0 = -2.36211856075265944077e-03;
+    const double pa1 = 4.14856118683748331666e-01;
+    const double pa2 = -3.72207876035701323847e-01;
+    const double pa3 = 3.18346619901161753674e-01;
+    const double pa4 = -1.10894694282396677476e-01;
+    const double pa5 = 3.54783043256182359371e-02;
+    const double pa6 = -2.16637559486879084300e-03;
+    const double qa1 = 1.06420880400844228286e-01;
+    const double qa2 = 5.40397917702171048937e-01;
+    const double qa3 = 7.18286544141962662868e-02;
+    const double qa4 = 1.26171219808761642112e-01;
+    const double qa5 = 1.36370839120290507362e-02;
+    const double qa6 = 1.19844998467991074170e-02;
+    // Coefficients for approximation to erfc in [1.25, 1/0.35]
+    const double ra0 = -9.86494403484714822705e-03;
+    const double ra1 = -6.93858572707181764372e-01;
+    const double ra2 = -1.05586262253232909814e+01;
+    const double ra3 = -6.23753324503260060396e+01;
+    const double ra4 = -1.62396669462573470355e+02;
+    const double ra5 = -1.84605092906711035994e+02;
+    const double ra6 = -8.12874355063065934246e+01;
+    const double ra7 = -9.81432934416914548592e+00;
+    const double sa1 = 1.96512716674392571292e+01;
+    const double sa2 = 1.37657754143519042600e+02;
+    const double sa3 = 4.34565877475229228821e+02;
+    const double sa4 = 6.45387271733267880336e+02;
+    const double sa5 = 4.29008140027567833386e+02;
+    const double sa6 = 1.08635005541779435134e+02;
+    const double sa7 = 6.57024977031928170135e+00;
+    const double sa8 = -6.04244152148580987438e-02;
+    // Coefficients for approximation to erfc in [1/.35, 28]
+    const double rb0 = -9.86494292470009928597e-03;
+    const double rb1 = -7.99283237680523006574e-01;
+    const double rb2 = -1.77579549177547519889e+01;
+    const double rb3 = -1.60636384855821916062e+02;
+    const double rb4 = -6.37566443368389627722e+02;
+    const double rb5 = -1.02509513161107724954e+03;
+    const double rb6 = -4.83519191608651397019e+02;
+    const double sb1 = 3.03380607434824582924e+01;
+    const double sb2 = 3.25792512996573918826e+02;
+    const double sb3 = 1.53672958608443695994e+03;
+    const double sb4 = 3.19985821950859553908e+03;
+    const double sb5 = 2.55305040643316442583e+03;
+    const double sb6 = 4.74528541206955367215e+02;
+    const double sb7 = -2.24409524465858183362e+01;
+
+    if (x != x)
+    {
+        return x;
+    }
+    int sign = 0;
+    if (x < 0)
+    {
+        x = -x;
+        sign = 1;
+    }
+    if (x < 0.84375)
+    {
+        double temp;
+        if (x < Small)
+        {
+            if (x < VeryTiny)
+            {
+                temp = 0.125 * (8.0 * x + efx8 * x); // avoid underflow
+            }
+            else
+            {
+                temp = x + efx * x;
+            }
+        }
+        else
+        {
+            double z = x * x;
+            double r = pp0 + z * (pp1 + z * (pp2 + z * (pp3 + z * pp4)));
+            double s = 1 + z * (qq1 + z * (qq2 + z * (qq3 + z * (qq4 + z * qq5))));
+            temp = x + x * (r / s);
+        }
+        return sign ? -temp : temp;
+    }
+    if (x < 1.25)
+    {
+        double s = x - 1;
+        double P = pa0 + s * (pa1 + s * (pa2 + s * (pa3 + s * (pa4 + s * (pa5 + s * pa6)))));
+        double Q = 1 + s * (qa1 + s * (qa2 + s * (qa3 + s * (qa4 + s * (qa5 + s * qa6)))));
+        return sign ? -erx - P / Q : erx + P / Q;
+    }
+    if (x >= 6)
+    {
+        return sign ? -1 : 1;
+    }
+    double s = 1 / (x * x);
+    double R, S;
+    if (x < 1 / 0.35)
+    {
+        R = ra0 + s * (ra1 + s * (ra2 + s * (ra3 + s * (ra4 + s * (ra5 + s * (ra6 + s * ra7))))));
+        S = 1 + s * (sa1 + s * (sa2 + s * (sa3 + s * (sa4 + s * (sa5 + s * (sa6 + s * (sa7 + s * sa8)))))));
+    }
+    else
+    {
+        R = rb0 + s * (rb1 + s * (rb2 + s * (rb3 + s * (rb4 + s * (rb5 + s * rb6)))));
+        S = 1 + s * (sb1 + s * (sb2 + s * (sb3 + s * (sb4 + s * (sb5 + s * (sb6 + s * sb7))))));
+    }
+    // pseudo-single (20-bit) precision x
+    union goat_double z = {x};
+    z.u &= 0xffffffff00000000ULL;
+    double r = exp(-z.f * z.f - 0.5625) * exp((z.f - x) * (z.f + x) + R / S);
+    return sign ? r / x - 1 : 1 - r / x;
+}
+#endif
+
+#ifdef GOAT_LIBC_erff
+float erff(float x)
+{
+    return (float)erf(x);
+}
+#endif
@@ -0,0 +1,92 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Freestanding implementations of the memory routines that compilers emit
+// calls to, even with -fno-builtin. GoAT includes this file into translation
+// units whose object files reference these routines, defining GOAT_LIBC_<name>
+// for each of them.
+
+#include <stddef.h>
+
+// Keep the compiler from recognizing the loops below as the routines they
+// implement, which would turn them into infinite recursion.
+#if defined(__clang__)
+#define GOAT_NO_BUILTIN __attribute__((no_builtin))
+#else
+#define GOAT_NO_BUILTIN __attribute__((optimize("no-tree-loop-distribute-patterns")))
+#endif
+
+void *memset(void *dst, int c, size_t n);
+
+#ifdef GOAT_LIBC_memcpy
+GOAT_NO_BUILTIN void *memcpy(void *dst, const void *src, size_t n)
+{
+    unsigned char *d = dst;
+    const unsigned char *s = src;
+    for (size_t i = 0; i < n; i++)
+    {
+        d[i] = s[i];
+    }
+    return dst;
+}
+#endif
+
+#ifdef GOAT_LIBC_memmove
+GOAT_NO_BUILTIN void *memmove(void *dst, const void *src, size_t n)
+{
+    unsigned char *d = dst;
+    const unsigned char *s = src;
+    if (d < s)
+    {
+        for (size_t i = 0; i < n; i++)
+        {
+            d[i] = s[i];
+        }
+    }
+    else
+    {
+        for (size_t i = n; i > 0; i--)
+        {
+            d[i - 1] = s[i - 1];
+        }
+    }
+    return dst;
+}
+#endif
+
+#ifdef GOAT_LIBC_memset
+GOAT_NO_BUILTIN void *memset(void *dst, int c, size_t n)
+{
+    unsigned char *d = dst;
+    for (size_t i = 0; i < n; i++)
+    {
+        d[i] = (unsigned char)c;
+    }
+    return dst;
+}
+#endif
+
+#ifdef GOAT_LIBC_bzero
+GOAT_NO_BUILTIN void bzero(void *dst, size_t n)
+{
+    memset(dst, 0, n);
+}
+#endif
+
+#ifdef GOAT_LIBC___bzero
+GOAT_NO_BUILTIN void __bzero(void *dst, size_t n)
+{
+    memset(dst, 0, n);
+}
+#endif
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -35,8 +36,11 @@ var (
 
 	symbolLine = regexp.MustCompile(`^\w+\s+<\w+>:$`)
 	dataLine   = regexp.MustCompile(`^\w+:\s+\w+\s+.+$`)
-	pcHiLine   = regexp.MustCompile(`^pcalau12i\s+(\$[a-z0-9]+), %pc_hi20\(([A-Za-z_][A-Za-z0-9_]*)\)$`)
-	pcLoLine   = regexp.MustCompile(`^addi\.d\s+(\$[a-z0-9]+), (\$[a-z0-9]+), %pc_lo12\(([A-Za-z_][A-Za-z0-9_]*)\)$`)
+	pcHiLine   = regexp.MustCompile(`^pcalau12i\s+(\$[a-z0-9]+), %pc_hi20\(([A-Za-z_.$][\w.$]*)(?:\+\d+)?\)$`)
+	pcLoSymbol = regexp.MustCompile(`%pc_lo12\(([A-Za-z_.$][\w.$]*(?:\+\d+)?)\)`)
+	callLine   = regexp.MustCompile(`^bl\s+(?:%plt\()?([A-Za-z_][A-Za-z0-9_]*)\)?$`)
+	tailLine   = regexp.MustCompile(`^b\s+(?:%plt\()?([A-Za-z_][A-Za-z0-9_]*)\)?$`)
+	branchLine = regexp.MustCompile(`^(b|beq|bne|blt|bge|bltu|bgeu|beqz|bnez|bceqz|bcnez)\s+(?:(\$\w+),\s*)?(?:(\$\w+),\s*)?\.(\w+)$`)
 
 	registers   = []string{"R4", "R5", "R6", "R7", "R8", "R9", "R10", "R11"}
 	fpRegisters = []string{"F0", "F1", "F2", "F3", "F4", "F5", "F6", "F7"}
@@ -75,61 +79,147 @@ var (
 		"$s8":   "R31",
 		"$s9":   "R22",
 	}
+	// opAlias maps the branch instructions to Go ones. Other instructions,
+	// including those of LSX and LASX, are emitted as words.
 	opAlias = map[string]string{
-		"b":    "JMP",
-		"bnez": "BNE",
+		"b":     "JMP",
+		"beq":   "BEQ",
+		"bne":   "BNE",
+		"blt":   "BLT",
+		"bge":   "BGE",
+		"bltu":  "BLTU",
+		"bgeu":  "BGEU",
+		"beqz":  "BEQ",
+		"bnez":  "BNE",
+		"bceqz": "BFPF",
+		"bcnez": "BFPT",
 	}
 	dataSymbols []internal.DataSymbol
 )
 
+var (
+	callTarget     = internal.MatchCallee(callLine)
+	tailCallTarget = internal.MatchCallee(tailLine)
+)
+
+// reservedRegisters are the registers reserved by Go, which clang cannot be
+// told to keep free.
+var reservedRegisters = []internal.ReservedRegister{
+	// R22 points to the Go routine structure.
+	{Names: []string{"$r22", "$fp", "$s9"}},
+}
+
+// hwcaps are the vector extensions, as named by HWCAP_LOONGARCH_* of Linux.
+var hwcaps = []internal.HWCap{
+	{Name: "LSX", Macro: "__loongarch_sx", Entry: internal.AtHWCap, Bit: 4},
+	{Name: "LASX", Macro: "__loongarch_asx", Entry: internal.AtHWCap, Bit: 5},
+}
+
 func init() {
 	internal.RegisterTarget("loong64", internal.Target{
-		GOARCH:             "loong64",
-		BuildTags:          "//go:build !noasm && loong64\n",
-		ClangTriple:        "loongarch64-linux-gnu",
+		GOARCH:      "loong64",
+		BuildTags:   "//go:build !noasm && loong64\n",
+		ClangTriple: "loongarch64-linux-gnu",
+		// The frame pointer is R22, which points to the Go routine structure.
+		ClangOptions:       []string{"-fomit-frame-pointer"},
+		HWCaps:             hwcaps,
 		ParseAssembly:      parseAssembly,
 		ParseObjectDump:    parseObjectDump,
 		GenerateGoAssembly: generateGoAssembly,
 	})
 }
 
+// relocated reports whether a relocation of an instruction is resolved by its
+// rewrite into Go assembly.
+func relocated(lines []internal.Line, index int, relocation internal.Relocation) bool {
+	asm := lines[index].Assembly
+	switch relocation.Type {
+	case "R_LARCH_B16", "R_LARCH_B21", "R_LARCH_B26":
+		if _, ok := callTarget(asm); ok {
+			return true
+		}
+		_, ok := tailCallTarget(asm)
+		return ok || branchLine.MatchString(asm)
+	case "R_LARCH_PCALA_HI20":
+		return pcHiLine.MatchString(asm)
+	case "R_LARCH_PCALA_LO12":
+		_, err := patchLo12(lines[index])
+		return err == nil
+	case "R_LARCH_RELAX", "R_LARCH_ALIGN":
+		// Hints for linker relaxation, which leave the instruction as it is.
+		return true
+	}
+	return false
+}
+
+// patchLo12 returns the binary of an instruction that adds the low 12 bits of
+// a symbol address, with the immediate replaced by the offset from the symbol.
+// The preceding PCALAU12I is rewritten to load the full address of the Go
+// symbol.
+func patchLo12(line internal.Line) (string, error) {
+	matches := pcLoSymbol.FindStringSubmatch(line.Assembly)
+	if matches == nil {
+		return "", fmt.Errorf("missing %%pc_lo12 operand in %q", line.Assembly)
+	}
+	_, offset, ok := internal.SplitSymbolOffset(matches[1])
+	if !ok || offset > 0x7ff {
+		return "", fmt.Errorf("unsupported operand %q", matches[1])
+	}
+	word, err := strconv.ParseUint(line.Binary, 16, 32)
+	if err != nil {
+		return "", err
+	}
+	word = word&^(0xfff<<10) | uint64(offset)<<10
+	return fmt.Sprintf("%08x", word), nil
+}
+
 func generateLine(line internal.Line) string {
 	var builder strings.Builder
 	builder.WriteString("\t")
-	if matches := pcHiLine.FindStringSubmatch(line.Assembly); matches != nil {
+	if callee, ok := callTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("CALL %s<>(SB)", callee))
+	} else if callee, ok := tailCallTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("JMP %s<>(SB)", callee))
+	} else if matches := pcHiLine.FindStringSubmatch(line.Assembly); matches != nil {
 		if r, ok := registersAlias[matches[1]]; !ok {
 			_, _ = fmt.Fprintln(os.Stderr, "unexpected register alias:", matches[1])
 			os.Exit(1)
 		} else {
-			builder.WriteString(fmt.Sprintf("MOVV $%s<>(SB), %s", matches[2], r))
-		}
-	} else if pcLoLine.MatchString(line.Assembly) {
-		// The preceding PCALAU12I is rewritten to load the full Go symbol address.
-	} else if strings.HasPrefix(line.Assembly, "b") && !strings.HasPrefix(line.Assembly, "bstrins") && !strings.HasPrefix(line.Assembly, "bstrpick") {
-		splits := strings.Split(line.Assembly, ".")
-		op := strings.TrimSpace(splits[0])
-		registers := strings.FieldsFunc(op, func(r rune) bool {
-			return unicode.IsSpace(r) || r == ','
-		})
-		if o, ok := opAlias[registers[0]]; !ok {
-			builder.WriteString(strings.ToUpper(registers[0]))
-		} else {
-			builder.WriteString(o)
+			builder.WriteString(fmt.Sprintf("MOVV $%s(SB), %s", internal.DataSymbolReference(matches[2], dataSymbols), r))
 		}
+	} else if matches := branchLine.FindStringSubmatch(line.Assembly); matches != nil {
+		builder.WriteString(opAlias[matches[1]])
 		builder.WriteRune(' ')
-		for i := 1; i < len(registers); i++ {
-			if r, ok := registersAlias[registers[i]]; !ok {
-				_, _ = fmt.Fprintln(os.Stderr, "unexpected register alias:", registers[i])
+		for _, register := range matches[2:4] {
+			if register == "" || register == "$fcc0" {
+				// FCC0 is the implicit operand of BFPT and BFPF.
+				continue
+			}
+			if condition, ok := strings.CutPrefix(register, "$fcc"); ok {
+				builder.WriteString("FCC" + condition + ",")
+			} else if r, ok := registersAlias[register]; !ok {
+				_, _ = fmt.Fprintln(os.Stderr, "unexpected register alias:", register)
 				os.Exit(1)
 			} else {
 				builder.WriteString(r)
 				builder.WriteRune(',')
 			}
 		}
-		builder.WriteString(splits[1])
+		builder.WriteString(matches[4])
 	} else {
+		binary := line.Binary
+		for _, relocation := range line.Relocations {
+			if relocation.Type == "R_LARCH_PCALA_LO12" {
+				patched, err := patchLo12(line)
+				if err != nil {
+					_, _ = fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				binary = patched
+			}
+		}
 		builder.WriteString("\t")
-		builder.WriteString(fmt.Sprintf("WORD $0x%v", line.Binary))
+		builder.WriteString(fmt.Sprintf("WORD $0x%v", binary))
 		builder.WriteString("\t// ")
 		builder.WriteString(line.Assembly)
 	}
@@ -137,7 +227,7 @@ func generateLine(line internal.Line) string {
 	return builder.String()
 }
 
-func parseAssembly(path string) (map[string][]internal.Line, map[string]int, error) {
+func parseAssembly(path string, globals []internal.Global) (map[string][]internal.Line, map[string]int, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, nil, err
@@ -154,35 +244,25 @@ func parseAssembly(path string) (map[string][]internal.Line, map[string]int, err
 		functions    = make(map[string][]internal.Line)
 		functionName string
 		labelName    string
-		dataName     string
-		dataSection  bool
-		data         []internal.DataSymbol
+		data         = internal.DataCollector{Globals: globals}
 	)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, ".section") {
-			dataSection = strings.Contains(trimmed, ".rodata") || strings.Contains(trimmed, ".data")
-		}
-		if parsed, ok, err := internal.ParseDataDirective(line); err != nil {
+		if ok, err := data.Collect(line, 8, binary.LittleEndian); err != nil {
 			return nil, nil, err
-		} else if ok && dataName != "" {
-			data = append(data, internal.DataSymbol{Name: dataName, Data: parsed})
-			dataName = ""
-		} else if attributeLine.MatchString(line) {
+		} else if ok {
+			continue
+		}
+		if attributeLine.MatchString(line) {
 			continue
 		} else if nameLine.MatchString(line) {
 			name, _, _ := strings.Cut(line, ":")
 			if strings.HasPrefix(name, ".") {
 				continue
 			}
-			if dataSection {
-				dataName = name
-			} else {
-				functionName = name
-				functions[functionName] = make([]internal.Line, 0)
-			}
+			functionName = name
+			functions[functionName] = make([]internal.Line, 0)
 		} else if labelLine.MatchString(line) {
 			labelName = strings.Split(line, ":")[0]
 			labelName = labelName[1:]
@@ -210,7 +290,7 @@ func parseAssembly(path string) (map[string][]internal.Line, map[string]int, err
 	if err = scanner.Err(); err != nil {
 		return nil, nil, err
 	}
-	dataSymbols = data
+	dataSymbols = data.Symbols
 	return functions, stackSizes, nil
 }
 
@@ -221,7 +301,12 @@ func parseObjectDump(dump string, functions map[string][]internal.Line) error {
 	)
 	for i, line := range strings.Split(dump, "\n") {
 		line = strings.TrimSpace(line)
-		if symbolLine.MatchString(line) {
+		if relocation, ok := internal.ParseRelocation(line); ok {
+			if lineNumber > 0 {
+				lines := functions[functionName]
+				lines[lineNumber-1].Relocations = append(lines[lineNumber-1].Relocations, relocation)
+			}
+		} else if symbolLine.MatchString(line) {
 			functionName = strings.Split(line, "<")[1]
 			functionName = strings.Split(functionName, ">")[0]
 			lineNumber = 0
@@ -259,8 +344,28 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 	var builder strings.Builder
 	builder.WriteString(buildTags)
 	builder.WriteString(header)
-	builder.WriteString(internal.GenerateDataSymbols(dataSymbols, binary.LittleEndian))
+	if err := internal.CheckDataAlignment(dataSymbols); err != nil {
+		return err
+	}
+	data, err := internal.GenerateDataSymbols(dataSymbols, binary.LittleEndian)
+	if err != nil {
+		return err
+	}
+	builder.WriteString(data)
+	locals, err := internal.LocalFunctions(functions, dataSymbols, callTarget, tailCallTarget)
+	if err != nil {
+		return err
+	}
+	if err = internal.CheckRelocations(functions, locals, relocated); err != nil {
+		return err
+	}
+	if err = internal.CheckReservedRegisters(functions, locals, reservedRegisters); err != nil {
+		return err
+	}
 	for _, function := range functions {
+		if function.Local {
+			continue
+		}
 		returnSize := 0
 		if function.Type != "void" {
 			returnSize += 8
@@ -329,7 +434,12 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 				builder.WriteString(label)
 				builder.WriteString(":\n")
 			}
-			if line.Assembly == "ret" {
+			callee, isTailCall := tailCallTarget(line.Assembly)
+			if isTailCall {
+				// The callee must return here to store the result.
+				builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+			}
+			if isTailCall || line.Assembly == "ret" {
 				if frameSize > 0 {
 					builder.WriteString(fmt.Sprintf("\tADDV $%d, R3\n", frameSize))
 				}
@@ -351,6 +461,16 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 			}
 		}
 	}
+	for _, function := range locals {
+		builder.WriteString(internal.LocalTextHeader(function.Name))
+		for _, line := range function.Lines {
+			for _, label := range line.Labels {
+				builder.WriteString(label)
+				builder.WriteString(":\n")
+			}
+			builder.WriteString(generateLine(line))
+		}
+	}
 
 	// write file
 	f, err := os.Create(goAssemblyPath)
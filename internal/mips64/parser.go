@@ -0,0 +1,566 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mips64
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorse-io/goat/internal"
+	"github.com/klauspost/asmfmt"
+)
+
+var (
+	attributeLine = regexp.MustCompile(`^\s+\..+$`)
+	nameLine      = regexp.MustCompile(`^\w+:.*$`)
+	labelLine     = regexp.MustCompile(`^\.\w+_\d+:.*$`)
+	codeLine      = regexp.MustCompile(`^\s+\w+.+$`)
+
+	symbolLine = regexp.MustCompile(`^\w+\s+<\w+>:$`)
+	dataLine   = regexp.MustCompile(`^\w+:\s+\w+\s+.+$`)
+	jmpLine    = regexp.MustCompile(`^(b|j|beq|bne|beqz|bnez|bgez|bltz|bgtz|blez|bc1t|bc1f)\s+(?:(\$\w+), )?(?:(\$\w+), )?\.(\w+_\d+)$`)
+	callLine   = regexp.MustCompile(`^jal\s+([A-Za-z_][A-Za-z0-9_]*)$`)
+	tailLine   = regexp.MustCompile(`^j\s+([A-Za-z_][A-Za-z0-9_]*)$`)
+	retLine    = regexp.MustCompile(`^jr\s+\$(ra|31)$`)
+	hiLine     = regexp.MustCompile(`^lui\s+(\$\w+), %(highest|hi)\(([A-Za-z_.$][\w.$]*)(?:\+\d+)?\)$`)
+	upperLine  = regexp.MustCompile(`^daddiu\s+(\$\w+), (\$\w+), %(higher|hi)\([A-Za-z_.$][\w.$]*(?:\+\d+)?\)$`)
+	shiftLine  = regexp.MustCompile(`^dsll\s+(\$\w+), (\$\w+), 16$`)
+	loSymbol   = regexp.MustCompile(`%lo\(([A-Za-z_.$][\w.$]*(?:\+\d+)?)\)`)
+
+	dataSymbols []internal.DataSymbol
+)
+
+var (
+	callTarget     = internal.MatchCallee(callLine)
+	tailCallTarget = internal.MatchCallee(tailLine)
+)
+
+// branches are the Go names of branches to labels.
+var branches = map[string]string{
+	"b":    "JMP",
+	"j":    "JMP",
+	"beq":  "BEQ",
+	"bne":  "BNE",
+	"beqz": "BEQ",
+	"bnez": "BNE",
+	"bgez": "BGEZ",
+	"bltz": "BLTZ",
+	"bgtz": "BGTZ",
+	"blez": "BLEZ",
+	"bc1t": "BFPT",
+	"bc1f": "BFPF",
+}
+
+// reservedRegisters are the registers reserved by Go, which clang cannot be
+// told to keep free. See https://go.dev/doc/asm#mips
+var reservedRegisters = []internal.ReservedRegister{
+	// R30 points to the Go routine structure.
+	{Names: []string{"$30", "$fp", "$s8"}},
+	// R23 is the temporary register of the assembler.
+	{Names: []string{"$23", "$s7"}},
+	// R28 is reserved for the static base.
+	{Names: []string{"$28", "$gp"}},
+}
+
+// The code is compiled for the N64 ABI without position independent calls, so
+// that symbols are addressed by absolute relocations, and without filling the
+// delay slots of branches, since Go assembly fills them itself.
+func init() {
+	options := []string{"-fno-pic", "-mno-abicalls", "-mno-gpopt", "-fomit-frame-pointer",
+		"-fno-jump-tables", "-mllvm", "-disable-mips-delay-filler"}
+	internal.RegisterTarget("mips64", internal.Target{
+		GOARCH:             "mips64",
+		BuildTags:          "//go:build !noasm && mips64 && mips64.hardfloat\n",
+		ClangTriple:        "mips64-linux-gnuabi64",
+		ClangOptions:       options,
+		ParseAssembly:      parser{binary.BigEndian}.parseAssembly,
+		ParseObjectDump:    parseObjectDump,
+		GenerateGoAssembly: parser{binary.BigEndian}.generateGoAssembly,
+	})
+	internal.RegisterTarget("mips64le", internal.Target{
+		GOARCH:             "mips64le",
+		BuildTags:          "//go:build !noasm && mips64le && mips64le.hardfloat\n",
+		ClangTriple:        "mips64el-linux-gnuabi64",
+		ClangOptions:       options,
+		ParseAssembly:      parser{binary.LittleEndian}.parseAssembly,
+		ParseObjectDump:    parseObjectDump,
+		GenerateGoAssembly: parser{binary.LittleEndian}.generateGoAssembly,
+	})
+}
+
+// parser parses and generates the assembly of a target with its byte order.
+type parser struct {
+	order binary.ByteOrder
+}
+
+// relocated reports whether a relocation of an instruction is resolved by its
+// rewrite into Go assembly.
+func relocated(lines []internal.Line, index int, relocation internal.Relocation) bool {
+	asm := lines[index].Assembly
+	switch relocation.Type {
+	case "R_MIPS_26":
+		_, call := callTarget(asm)
+		_, tail := tailCallTarget(asm)
+		return call || tail || jmpLine.MatchString(asm)
+	case "R_MIPS_HIGHEST":
+		return hiLine.MatchString(asm)
+	case "R_MIPS_HIGHER", "R_MIPS_HI16":
+		return hiLine.MatchString(asm) || upperLine.MatchString(asm)
+	case "R_MIPS_LO16":
+		_, err := patchLo16(lines[index])
+		return err == nil
+	case "R_MIPS_NONE":
+		// The second and third types of a composite relocation.
+		return true
+	}
+	return false
+}
+
+// goRegister returns the Go name of a register.
+func goRegister(name string) (string, error) {
+	switch name {
+	case "$zero":
+		return "R0", nil
+	case "$sp":
+		return "R29", nil
+	case "$ra":
+		return "R31", nil
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(name, "$")); err == nil && n < 32 {
+		return fmt.Sprintf("R%d", n), nil
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(name, "$f")); err == nil && n < 32 {
+		return fmt.Sprintf("F%d", n), nil
+	}
+	return "", fmt.Errorf("unexpected register %s", name)
+}
+
+// patchLo16 returns the binary of an instruction that adds the low 16 bits of
+// a symbol address, with the immediate replaced by the offset from the symbol.
+// The preceding LUI is rewritten to load the full address of the Go symbol.
+func patchLo16(line internal.Line) (string, error) {
+	matches := loSymbol.FindStringSubmatch(line.Assembly)
+	if matches == nil {
+		return "", fmt.Errorf("missing %%lo operand in %q", line.Assembly)
+	}
+	_, offset, ok := internal.SplitSymbolOffset(matches[1])
+	if !ok || offset > 0x7fff {
+		return "", fmt.Errorf("unsupported operand %q", matches[1])
+	}
+	word, err := strconv.ParseUint(line.Binary, 16, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08x", word&^0xffff|uint64(offset)), nil
+}
+
+// branch reports whether an instruction has a delay slot that is filled by the
+// Go assembler.
+func branch(asm string) bool {
+	_, call := callTarget(asm)
+	_, tail := tailCallTarget(asm)
+	return call || tail || jmpLine.MatchString(asm) || retLine.MatchString(asm)
+}
+
+// dropDelaySlots returns the lines of a function without the delay slots of
+// the branches that are translated into Go assembly, since the Go assembler
+// fills them with nops itself. The delay slots must be nops.
+func dropDelaySlots(function internal.Function) ([]internal.Line, error) {
+	var lines []internal.Line
+	for i := 0; i < len(function.Lines); i++ {
+		line := function.Lines[i]
+		lines = append(lines, line)
+		if !branch(line.Assembly) {
+			continue
+		}
+		if i+1 == len(function.Lines) || function.Lines[i+1].Assembly != "nop" || len(function.Lines[i+1].Labels) > 0 {
+			return nil, fmt.Errorf("function %s: delay slot of %q is not a nop", function.Name, line.Assembly)
+		}
+		i++
+	}
+	return lines, nil
+}
+
+// generateLine returns the Go assembly of an instruction. The high parts of a
+// symbol address are loaded by a single MOVV, and shifts counts the shifts of
+// each register that are left in the sequence.
+func generateLine(line internal.Line, shifts map[string]int) (string, error) {
+	var builder strings.Builder
+	if callee, ok := callTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+	} else if callee, ok := tailCallTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("\tJMP %s<>(SB)\n", callee))
+	} else if retLine.MatchString(line.Assembly) {
+		builder.WriteString("\tRET\n")
+	} else if matches := jmpLine.FindStringSubmatch(line.Assembly); matches != nil {
+		builder.WriteString("\t" + branches[matches[1]])
+		for _, operand := range matches[2:4] {
+			if operand == "" || operand == "$fcc0" {
+				continue
+			}
+			register, err := goRegister(operand)
+			if err != nil {
+				return "", err
+			}
+			builder.WriteString(" " + register + ",")
+		}
+		builder.WriteString(" " + matches[4] + "\n")
+	} else if matches := hiLine.FindStringSubmatch(line.Assembly); matches != nil {
+		register, err := goRegister(matches[1])
+		if err != nil {
+			return "", err
+		}
+		if matches[2] == "highest" {
+			shifts[matches[1]] = 2
+		}
+		builder.WriteString(fmt.Sprintf("\tMOVV $%s(SB), %s\n", internal.DataSymbolReference(matches[3], dataSymbols), register))
+	} else if matches := upperLine.FindStringSubmatch(line.Assembly); matches != nil && matches[1] == matches[2] {
+		// The upper parts are loaded along with the highest part.
+	} else if matches := shiftLine.FindStringSubmatch(line.Assembly); matches != nil && matches[1] == matches[2] && shifts[matches[1]] > 0 {
+		shifts[matches[1]]--
+	} else {
+		binary := line.Binary
+		for _, relocation := range line.Relocations {
+			if relocation.Type == "R_MIPS_LO16" {
+				patched, err := patchLo16(line)
+				if err != nil {
+					return "", err
+				}
+				binary = patched
+			}
+		}
+		builder.WriteString(fmt.Sprintf("\tWORD $0x%s\t// %s\n", binary, line.Assembly))
+	}
+	return builder.String(), nil
+}
+
+func (p parser) parseAssembly(path string, globals []internal.Global) (map[string][]internal.Line, map[string]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func(file *os.File) {
+		if err = file.Close(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}(file)
+
+	var (
+		stackSizes   = make(map[string]int)
+		functions    = make(map[string][]internal.Line)
+		functionName string
+		labelName    string
+		data         = internal.DataCollector{Globals: globals}
+	)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := internal.StripComment(scanner.Text(), "#")
+		if ok, err := data.Collect(line, 8, p.order); err != nil {
+			return nil, nil, err
+		} else if ok {
+			continue
+		}
+		if attributeLine.MatchString(line) {
+			continue
+		} else if nameLine.MatchString(line) {
+			name, _, _ := strings.Cut(line, ":")
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			functionName = name
+			functions[functionName] = make([]internal.Line, 0)
+		} else if labelLine.MatchString(line) {
+			labelName = strings.Split(line, ":")[0]
+			labelName = labelName[1:]
+			lines := functions[functionName]
+			if len(lines) == 1 || lines[len(lines)-1].Assembly != "" {
+				functions[functionName] = append(functions[functionName], internal.Line{Labels: []string{labelName}})
+			} else {
+				lines[len(lines)-1].Labels = append(lines[len(lines)-1].Labels, labelName)
+			}
+		} else if codeLine.MatchString(line) {
+			asm := strings.TrimSpace(line)
+			if labelName == "" {
+				functions[functionName] = append(functions[functionName], internal.Line{Assembly: asm})
+			} else {
+				lines := functions[functionName]
+				if len(lines) > 0 {
+					lines[len(lines)-1].Assembly = asm
+				}
+				labelName = ""
+			}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	dataSymbols = data.Symbols
+	return functions, stackSizes, nil
+}
+
+func parseObjectDump(dump string, functions map[string][]internal.Line) error {
+	var (
+		functionName string
+		lineNumber   int
+	)
+	for i, line := range strings.Split(dump, "\n") {
+		line = strings.TrimSpace(line)
+		if relocation, ok := internal.ParseRelocation(line); ok {
+			if lineNumber > 0 {
+				lines := functions[functionName]
+				lines[lineNumber-1].Relocations = append(lines[lineNumber-1].Relocations, relocation)
+			}
+		} else if symbolLine.MatchString(line) {
+			functionName = strings.Split(line, "<")[1]
+			functionName = strings.Split(functionName, ">")[0]
+			lineNumber = 0
+		} else if dataLine.MatchString(line) {
+			data := strings.Split(line, ":")[1]
+			data = strings.TrimSpace(data)
+			// objdump prints instructions as words, whatever the byte order.
+			binary, assembly, _ := strings.Cut(data, " ")
+			assembly = strings.TrimSpace(assembly)
+			lines := functions[functionName]
+			if assembly == "nop" && (lineNumber >= len(lines) || lines[lineNumber].Assembly != "nop") {
+				// Padding before aligned functions.
+				continue
+			}
+			if lineNumber >= len(lines) {
+				return fmt.Errorf("%d: unexpected objectdump line: %s", i, line)
+			}
+			lines[lineNumber].Binary = binary
+			lineNumber++
+		}
+	}
+	return nil
+}
+
+// generateArguments returns the instructions that pass the parameters of a
+// function following the N64 ABI, where the parameter in slot i is passed in
+// R(4+i) or F(12+i), and the rest in 8-byte stack slots. It also returns the
+// size of the Go argument frame and of the stack arguments.
+func generateArguments(params []internal.Parameter) (string, int, int) {
+	var (
+		loads  strings.Builder
+		stores []string
+		offset int
+	)
+	for i, param := range params {
+		sz := 8
+		if !param.Pointer {
+			sz = internal.SupportedTypes[param.Type]
+		}
+		if offset%sz != 0 {
+			offset += sz - offset%sz
+		}
+		var mov, register string
+		switch {
+		case param.Pointer:
+			mov, register = "MOVV", "R1"
+		case param.Type == "_Bool":
+			mov, register = "MOVBU", "R1"
+		case param.Type == "float":
+			mov, register = "MOVF", "F0"
+		case param.Type == "double":
+			mov, register = "MOVD", "F0"
+		default:
+			mov, register = "MOVV", "R1"
+		}
+		if i < 8 {
+			if register == "R1" {
+				register = fmt.Sprintf("R%d", 4+i)
+			} else {
+				register = fmt.Sprintf("F%d", 12+i)
+			}
+			loads.WriteString(fmt.Sprintf("\t%s %s+%d(FP), %s\n", mov, param.Name, offset, register))
+		} else {
+			// A float is stored at the start of its slot.
+			store := mov
+			if mov == "MOVBU" {
+				store = "MOVV"
+			}
+			stores = append(stores, fmt.Sprintf("\t%s %s+%d(FP), %s\n\t%s %s, %%d(R29)\n",
+				mov, param.Name, offset, register, store, register))
+		}
+		offset += sz
+	}
+	if offset%8 != 0 {
+		offset += 8 - offset%8
+	}
+	stackArgumentSize := len(stores) * 8
+	if stackArgumentSize%16 != 0 {
+		stackArgumentSize += 16 - stackArgumentSize%16
+	}
+	// The Go assembler does not adjust the offsets from FP to ADDV of R29, so
+	// the stack arguments are stored below R29 before it is moved past them.
+	var builder strings.Builder
+	for i, store := range stores {
+		builder.WriteString(fmt.Sprintf(store, 8*i-stackArgumentSize))
+	}
+	builder.WriteString(loads.String())
+	if stackArgumentSize > 0 {
+		builder.WriteString(fmt.Sprintf("\tADDV $-%d, R29\n", stackArgumentSize))
+	}
+	return builder.String(), offset, stackArgumentSize
+}
+
+func resultSize(typ string) int {
+	switch typ {
+	case "void":
+		return 0
+	case "_Bool":
+		return 1
+	case "float":
+		return 4
+	default:
+		return 8
+	}
+}
+
+// storeResult returns the instructions that store the result of a function.
+func storeResult(typ string, offset int) (string, error) {
+	switch typ {
+	case "void":
+		return "", nil
+	case "_Bool":
+		return fmt.Sprintf("\tMOVB R2, result+%d(FP)\n", offset), nil
+	case "int64_t", "long":
+		return fmt.Sprintf("\tMOVV R2, result+%d(FP)\n", offset), nil
+	case "float":
+		return fmt.Sprintf("\tMOVF F0, result+%d(FP)\n", offset), nil
+	case "double":
+		return fmt.Sprintf("\tMOVD F0, result+%d(FP)\n", offset), nil
+	default:
+		return "", fmt.Errorf("unsupported return type: %v", typ)
+	}
+}
+
+func (p parser) generateGoAssembly(buildTags string, header string, goAssemblyPath string, functions []internal.Function) error {
+	// generate code
+	var builder strings.Builder
+	builder.WriteString(buildTags)
+	builder.WriteString(header)
+	if err := internal.CheckDataAlignment(dataSymbols); err != nil {
+		return err
+	}
+	data, err := internal.GenerateDataSymbols(dataSymbols, p.order)
+	if err != nil {
+		return err
+	}
+	builder.WriteString(data)
+	locals, err := internal.LocalFunctions(functions, dataSymbols, callTarget, tailCallTarget)
+	if err != nil {
+		return err
+	}
+	if err = internal.CheckRelocations(functions, locals, relocated); err != nil {
+		return err
+	}
+	if err = internal.CheckReservedRegisters(functions, locals, reservedRegisters); err != nil {
+		return err
+	}
+	for _, function := range functions {
+		if function.Local {
+			continue
+		}
+		lines, err := dropDelaySlots(function)
+		if err != nil {
+			return err
+		}
+		arguments, offset, stackArgumentSize := generateArguments(function.Parameters)
+		returnLabel := fmt.Sprintf("%s_return", function.Name)
+		builder.WriteString(fmt.Sprintf("\nTEXT ·%v(SB), $0-%d\n", function.Name, offset+resultSize(function.Type)))
+		builder.WriteString(arguments)
+		shifts := make(map[string]int)
+		for _, line := range lines {
+			for _, label := range line.Labels {
+				builder.WriteString(label)
+				builder.WriteString(":\n")
+			}
+			if line.Assembly == "" {
+				continue
+			}
+			if callee, ok := tailCallTarget(line.Assembly); ok {
+				// The callee must return here to store the result.
+				builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+				builder.WriteString(fmt.Sprintf("\tJMP %s\n", returnLabel))
+			} else if retLine.MatchString(line.Assembly) {
+				builder.WriteString(fmt.Sprintf("\tJMP %s\n", returnLabel))
+			} else {
+				code, err := generateLine(line, shifts)
+				if err != nil {
+					return fmt.Errorf("function %s: %w", function.Name, err)
+				}
+				builder.WriteString(code)
+			}
+		}
+		builder.WriteString(returnLabel)
+		builder.WriteString(":\n")
+		if stackArgumentSize > 0 {
+			builder.WriteString(fmt.Sprintf("\tADDV $%d, R29\n", stackArgumentSize))
+		}
+		result, err := storeResult(function.Type, offset)
+		if err != nil {
+			return err
+		}
+		builder.WriteString(result)
+		builder.WriteString("\tRET\n")
+	}
+	for _, function := range locals {
+		lines, err := dropDelaySlots(function)
+		if err != nil {
+			return err
+		}
+		builder.WriteString(internal.LocalTextHeader(function.Name))
+		shifts := make(map[string]int)
+		for _, line := range lines {
+			for _, label := range line.Labels {
+				builder.WriteString(label)
+				builder.WriteString(":\n")
+			}
+			if line.Assembly == "" {
+				continue
+			}
+			code, err := generateLine(line, shifts)
+			if err != nil {
+				return fmt.Errorf("function %s: %w", function.Name, err)
+			}
+			builder.WriteString(code)
+		}
+	}
+
+	// write file
+	f, err := os.Create(goAssemblyPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		if err = f.Close(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}(f)
+	bytes, err := asmfmt.Format(strings.NewReader(builder.String()))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(bytes)
+	return err
+}
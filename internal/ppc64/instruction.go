@@ -0,0 +1,351 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ppc64
+
+// Shifts of the 5-bit register fields of an instruction word. The Power ISA
+// numbers bits from the most significant one, so RT/RS is bits 6-10, RA is bits
+// 11-15, RB is bits 16-20 and RC of VA-form is bits 21-25.
+const (
+	fieldRT = 21
+	fieldRA = 16
+	fieldRB = 11
+	fieldRC = 6
+)
+
+var (
+	gprRT     = []uint{fieldRT}
+	gprRA     = []uint{fieldRA}
+	gprRTRA   = []uint{fieldRT, fieldRA}
+	gprRARB   = []uint{fieldRA, fieldRB}
+	gprRTRARB = []uint{fieldRT, fieldRA, fieldRB}
+	gprVA     = []uint{fieldRT, fieldRA, fieldRB, fieldRC}
+)
+
+// primaryFields maps the primary opcodes of D, DS, DQ and M-form instructions
+// to their fields that hold general purpose registers. The other fields hold
+// immediates or floating-point, vector and condition registers.
+var primaryFields = map[uint32][]uint{
+	2:  gprRA,     // tdi
+	3:  gprRA,     // twi
+	6:  gprRA,     // lxvp, stxvp
+	7:  gprRTRA,   // mulli
+	8:  gprRTRA,   // subfic
+	10: gprRA,     // cmpli
+	11: gprRA,     // cmpi
+	12: gprRTRA,   // addic
+	13: gprRTRA,   // addic.
+	14: gprRTRA,   // addi
+	15: gprRTRA,   // addis
+	20: gprRTRA,   // rlwimi
+	21: gprRTRA,   // rlwinm
+	23: gprRTRARB, // rlwnm
+	24: gprRTRA,   // ori
+	25: gprRTRA,   // oris
+	26: gprRTRA,   // xori
+	27: gprRTRA,   // xoris
+	28: gprRTRA,   // andi.
+	29: gprRTRA,   // andis.
+	32: gprRTRA,   // lwz
+	33: gprRTRA,   // lwzu
+	34: gprRTRA,   // lbz
+	35: gprRTRA,   // lbzu
+	36: gprRTRA,   // stw
+	37: gprRTRA,   // stwu
+	38: gprRTRA,   // stb
+	39: gprRTRA,   // stbu
+	40: gprRTRA,   // lhz
+	41: gprRTRA,   // lhzu
+	42: gprRTRA,   // lha
+	43: gprRTRA,   // lhau
+	44: gprRTRA,   // sth
+	45: gprRTRA,   // sthu
+	48: gprRA,     // lfs
+	49: gprRA,     // lfsu
+	50: gprRA,     // lfd
+	51: gprRA,     // lfdu
+	52: gprRA,     // stfs
+	53: gprRA,     // stfsu
+	54: gprRA,     // stfd
+	55: gprRA,     // stfdu
+	57: gprRA,     // lfdp, lxsd, lxssp
+	58: gprRTRA,   // ld, ldu, lwa
+	61: gprRA,     // stfdp, stxsd, stxssp, lxv, stxv
+}
+
+// extendedFields maps the extended opcodes of X, XO, XS, XFX and XX1-form
+// instructions with primary opcode 31 to their fields that hold general purpose
+// registers. XO-form instructions are listed without overflow.
+var extendedFields = map[uint32][]uint{
+	0:    gprRARB,   // cmp
+	4:    gprRARB,   // tw
+	6:    gprRARB,   // lvsl
+	7:    gprRARB,   // lvebx
+	8:    gprRTRARB, // subfc
+	9:    gprRTRARB, // mulhdu
+	10:   gprRTRARB, // addc
+	11:   gprRTRARB, // mulhwu
+	12:   gprRARB,   // lxsiwzx
+	13:   gprRARB,   // lxvrbx
+	19:   gprRT,     // mfcr, mfocrf
+	20:   gprRTRARB, // lwarx
+	21:   gprRTRARB, // ldx
+	23:   gprRTRARB, // lwzx
+	24:   gprRTRARB, // slw
+	26:   gprRTRARB, // cntlzw
+	27:   gprRTRARB, // sld
+	28:   gprRTRARB, // and
+	32:   gprRARB,   // cmpl
+	38:   gprRARB,   // lvsr
+	39:   gprRARB,   // lvehx
+	40:   gprRTRARB, // subf
+	45:   gprRARB,   // lxvrhx
+	51:   gprRA,     // mfvsrd
+	52:   gprRTRARB, // lbarx
+	53:   gprRTRARB, // ldux
+	54:   gprRARB,   // dcbst
+	55:   gprRTRARB, // lwzux
+	58:   gprRTRARB, // cntlzd
+	60:   gprRTRARB, // andc
+	68:   gprRARB,   // td
+	71:   gprRARB,   // lvewx
+	73:   gprRTRARB, // mulhd
+	75:   gprRTRARB, // mulhw
+	76:   gprRARB,   // lxsiwax
+	77:   gprRARB,   // lxvrwx
+	84:   gprRTRARB, // ldarx
+	86:   gprRARB,   // dcbf
+	87:   gprRTRARB, // lbzx
+	103:  gprRARB,   // lvx
+	104:  gprRTRARB, // neg
+	109:  gprRARB,   // lxvrdx
+	115:  gprRA,     // mfvsrwz
+	116:  gprRTRARB, // lharx
+	119:  gprRTRARB, // lbzux
+	122:  gprRTRARB, // popcntb
+	124:  gprRTRARB, // nor
+	128:  gprRT,     // setb
+	135:  gprRARB,   // stvebx
+	136:  gprRTRARB, // subfe
+	138:  gprRTRARB, // adde
+	140:  gprRARB,   // stxsiwx
+	141:  gprRARB,   // stxvrbx
+	144:  gprRT,     // mtcrf, mtocrf
+	149:  gprRTRARB, // stdx
+	150:  gprRTRARB, // stwcx.
+	151:  gprRTRARB, // stwx
+	154:  gprRTRARB, // prtyw
+	167:  gprRARB,   // stvehx
+	170:  gprRTRARB, // addex
+	173:  gprRARB,   // stxvrhx
+	179:  gprRA,     // mtvsrd
+	181:  gprRTRARB, // stdux
+	183:  gprRTRARB, // stwux
+	186:  gprRTRARB, // prtyd
+	192:  gprRARB,   // cmprb
+	199:  gprRARB,   // stvewx
+	200:  gprRTRARB, // subfze
+	202:  gprRTRARB, // addze
+	205:  gprRARB,   // stxvrwx
+	211:  gprRA,     // mtvsrwa
+	214:  gprRTRARB, // stdcx.
+	215:  gprRTRARB, // stbx
+	224:  gprRARB,   // cmpeqb
+	231:  gprRARB,   // stvx
+	232:  gprRTRARB, // subfme
+	233:  gprRTRARB, // mulld
+	234:  gprRTRARB, // addme
+	235:  gprRTRARB, // mullw
+	237:  gprRARB,   // stxvrdx
+	243:  gprRA,     // mtvsrwz
+	246:  gprRARB,   // dcbtst
+	247:  gprRTRARB, // stbux
+	252:  gprRTRARB, // bpermd
+	265:  gprRTRARB, // modud
+	266:  gprRTRARB, // add
+	267:  gprRTRARB, // moduw
+	268:  gprRARB,   // lxvx
+	269:  gprRARB,   // lxvl
+	278:  gprRARB,   // dcbt
+	279:  gprRTRARB, // lhzx
+	284:  gprRTRARB, // eqv
+	301:  gprRARB,   // lxvll
+	307:  gprRA,     // mfvsrld
+	311:  gprRTRARB, // lhzux
+	316:  gprRTRARB, // xor
+	332:  gprRARB,   // lxvdsx
+	333:  gprRARB,   // lxvpx
+	339:  gprRT,     // mfspr
+	341:  gprRTRARB, // lwax
+	343:  gprRTRARB, // lhax
+	359:  gprRARB,   // lvxl
+	364:  gprRARB,   // lxvwsx
+	371:  gprRT,     // mftb
+	373:  gprRTRARB, // lwaux
+	375:  gprRTRARB, // lhaux
+	378:  gprRTRARB, // popcntw
+	393:  gprRTRARB, // divdeu
+	395:  gprRTRARB, // divweu
+	396:  gprRARB,   // stxvx
+	397:  gprRARB,   // stxvl
+	403:  gprRA,     // mtvsrws
+	407:  gprRTRARB, // sthx
+	412:  gprRTRARB, // orc
+	425:  gprRTRARB, // divde
+	427:  gprRTRARB, // divwe
+	429:  gprRARB,   // stxvll
+	435:  gprRARB,   // mtvsrdd
+	439:  gprRTRARB, // sthux
+	444:  gprRTRARB, // or
+	457:  gprRTRARB, // divdu
+	459:  gprRTRARB, // divwu
+	461:  gprRARB,   // stxvpx
+	467:  gprRT,     // mtspr
+	476:  gprRTRARB, // nand
+	487:  gprRARB,   // stvxl
+	489:  gprRTRARB, // divd
+	491:  gprRTRARB, // divw
+	506:  gprRTRARB, // popcntd
+	508:  gprRTRARB, // cmpb
+	524:  gprRARB,   // lxsspx
+	532:  gprRTRARB, // ldbrx
+	534:  gprRTRARB, // lwbrx
+	535:  gprRARB,   // lfsx
+	536:  gprRTRARB, // srw
+	538:  gprRTRARB, // cnttzw
+	539:  gprRTRARB, // srd
+	567:  gprRARB,   // lfsux
+	570:  gprRTRARB, // cnttzd
+	576:  nil,       // mcrxrx
+	588:  gprRARB,   // lxsdx
+	598:  nil,       // sync
+	599:  gprRARB,   // lfdx
+	631:  gprRARB,   // lfdux
+	652:  gprRARB,   // stxsspx
+	660:  gprRTRARB, // stdbrx
+	662:  gprRTRARB, // stwbrx
+	663:  gprRARB,   // stfsx
+	694:  gprRTRARB, // stbcx.
+	695:  gprRARB,   // stfsux
+	716:  gprRARB,   // stxsdx
+	726:  gprRTRARB, // sthcx.
+	727:  gprRARB,   // stfdx
+	755:  gprRT,     // darn
+	759:  gprRARB,   // stfdux
+	777:  gprRTRARB, // modsd
+	779:  gprRTRARB, // modsw
+	780:  gprRARB,   // lxvw4x
+	781:  gprRARB,   // lxsibzx
+	790:  gprRTRARB, // lhbrx
+	791:  gprRARB,   // lfdpx
+	792:  gprRTRARB, // sraw
+	794:  gprRTRARB, // srad
+	812:  gprRARB,   // lxvh8x
+	813:  gprRARB,   // lxsihzx
+	824:  gprRTRA,   // srawi
+	826:  gprRTRA,   // sradi
+	827:  gprRTRA,   // sradi
+	844:  gprRARB,   // lxvd2x
+	854:  nil,       // eieio
+	855:  gprRARB,   // lfiwax
+	876:  gprRARB,   // lxvb16x
+	887:  gprRARB,   // lfiwzx
+	890:  gprRTRA,   // extswsli
+	891:  gprRTRA,   // extswsli
+	908:  gprRARB,   // stxvw4x
+	909:  gprRARB,   // stxsibx
+	918:  gprRTRARB, // sthbrx
+	919:  gprRARB,   // stfdpx
+	922:  gprRTRARB, // extsh
+	940:  gprRARB,   // stxvh8x
+	941:  gprRARB,   // stxsihx
+	954:  gprRTRARB, // extsb
+	972:  gprRARB,   // stxvd2x
+	982:  gprRARB,   // icbi
+	983:  gprRARB,   // stfiwx
+	986:  gprRTRARB, // extsw
+	1004: gprRARB,   // stxvb16x
+	1014: gprRARB,   // dcbz
+}
+
+// vectorExtractFields holds the extended opcodes of the VX-form instructions
+// with primary opcode 4 that extract an element of a vector into RT by the
+// index in RA.
+var vectorExtractFields = map[uint32]bool{
+	1549: true, // vextublx
+	1613: true, // vextuhlx
+	1677: true, // vextuwlx
+	1805: true, // vextubrx
+	1869: true, // vextuhrx
+	1933: true, // vextuwrx
+}
+
+// registerFields returns the fields of an instruction word that hold general
+// purpose registers, or false if the instruction is not known.
+func registerFields(word uint32) ([]uint, bool) {
+	switch opcode := word >> 26; opcode {
+	case 19:
+		if (word>>1)&0x1f == 2 { // addpcis
+			return gprRT, true
+		}
+		// Branches and condition register instructions.
+		return nil, true
+	case 4:
+		switch {
+		case word&0x3f == 48 || word&0x3f == 49 || word&0x3f == 51: // maddhd, maddhdu, maddld
+			return gprVA, true
+		case vectorExtractFields[word&0x7ff]:
+			return gprRTRA, true
+		}
+		// Vector instructions.
+		return nil, true
+	case 16, 17, 18, 59, 60, 63:
+		// Branches, system calls, floating-point and VSX instructions.
+		return nil, true
+	case 30:
+		if xo := (word >> 1) & 0xf; xo == 8 || xo == 9 { // rldcl, rldcr
+			return gprRTRARB, true
+		}
+		// MD-form rotates, the shift of which is an immediate.
+		return gprRTRA, true
+	case 31:
+		if (word>>1)&0x1f == 15 { // isel
+			return gprRTRARB, true
+		}
+		fields, ok := extendedFields[(word>>1)&0x3ff]
+		return fields, ok
+	case 62:
+		if word&3 == 2 { // stq stores a pair of registers.
+			return nil, false
+		}
+		return gprRTRA, true // std, stdu
+	default:
+		fields, ok := primaryFields[opcode]
+		return fields, ok
+	}
+}
+
+// remapRegister replaces the general purpose register from with to in the
+// register fields of an instruction word.
+func remapRegister(word uint32, from, to uint32) (uint32, bool) {
+	fields, ok := registerFields(word)
+	if !ok {
+		return word, false
+	}
+	for _, shift := range fields {
+		if (word>>shift)&0x1f == from {
+			word = word&^(0x1f<<shift) | to<<shift
+		}
+	}
+	return word, true
+}
@@ -0,0 +1,783 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ppc64
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gorse-io/goat/internal"
+	"github.com/klauspost/asmfmt"
+)
+
+var (
+	attributeLine        = regexp.MustCompile(`^\s+\..+$`)
+	nameLine             = regexp.MustCompile(`^\w+:.*$`)
+	labelLine            = regexp.MustCompile(`^\.L[\w$]*:.*$`)
+	codeLine             = regexp.MustCompile(`^\s+\w+.+$`)
+	stackRefLine         = regexp.MustCompile(`-(\d+)\(([rR]?1)\)`)
+	stackMoveLine        = regexp.MustCompile(`^(std|ld|stw|lwz)\s+r(\d+),(-\d+)\(r1\)$`)
+	overflowLoadLine     = regexp.MustCompile(`^(ld|lwz|lbz)\s+r(\d+),(\d+)\(r1\)$`)
+	registerLine         = regexp.MustCompile(`\br(\d+)\b`)
+	reservedRegisterLine = regexp.MustCompile(`\br30\b`)
+	tocHighLine          = regexp.MustCompile(`^addis\s+r?(\d+),\s*r?2,\s*([.A-Za-z_$][\w.$]*)(\+\d+)?@toc@ha$`)
+	tocLowLine           = regexp.MustCompile(`^addi\s+r?(\d+),\s*r?(\d+),\s*([.A-Za-z_$][\w.$]*)(\+\d+)?@toc@l$`)
+	tocLowOperand        = regexp.MustCompile(`([.A-Za-z_$][\w.$]*(?:\+\d+)?)@toc@l\b`)
+	anchorSetLine        = regexp.MustCompile(`^\.set\s+(\.L[A-Za-z0-9_]+),\s*\.\s*\+\s*0$`)
+	numericLabelLine     = regexp.MustCompile(`^\d+:\s+(.+)$`)
+	callLine             = regexp.MustCompile(`^bl\s+([A-Za-z_][A-Za-z0-9_]*)$`)
+	tailLine             = regexp.MustCompile(`^b\s+([A-Za-z_][A-Za-z0-9_]*)$`)
+
+	symbolLine = regexp.MustCompile(`^[0-9a-f]+\s+<\w+>:$`)
+	dataLine   = regexp.MustCompile(`^[0-9a-f]+:\s+[0-9a-f]{2}(?:\s+[0-9a-f]{2}){3}.*$`)
+
+	registers   = []string{"R3", "R4", "R5", "R6", "R7", "R8", "R9", "R10"}
+	fpRegisters = []string{"F1", "F2", "F3", "F4", "F5", "F6", "F7", "F8", "F9", "F10", "F11", "F12", "F13"}
+	dataSymbols []internal.DataSymbol
+	dataAnchors = make(map[string]string)
+)
+
+var (
+	callTarget     = internal.MatchCallee(callLine)
+	tailCallTarget = internal.MatchCallee(tailLine)
+)
+
+const ppc64LinkageSize = 32
+
+// Clang cannot keep R0 and R30 free on ppc64. R30, the g register of Go, is
+// remapped to an unused callee-saved register in the machine code, and R0, which
+// Go expects to be zero, is cleared before returning to Go. Code is compiled
+// without PIC so that data is addressed relative to the TOC rather than loaded
+// from TOC entries. Big-endian code is compiled for ELFv2 too, which has the
+// same frame layout and no function descriptors.
+func init() {
+	internal.RegisterTarget("ppc64", internal.Target{
+		GOARCH:             "ppc64",
+		BuildTags:          "//go:build !noasm && ppc64\n",
+		ClangTriple:        "powerpc64-linux-gnu",
+		ClangOptions:       []string{"-O1", "-fno-pic", "-mabi=elfv2"},
+		ParseAssembly:      parser{binary.BigEndian}.parseAssembly,
+		ParseObjectDump:    parser{binary.BigEndian}.parseObjectDump,
+		GenerateGoAssembly: parser{binary.BigEndian}.generateGoAssembly,
+	})
+	internal.RegisterTarget("ppc64le", internal.Target{
+		GOARCH:             "ppc64le",
+		BuildTags:          "//go:build !noasm && ppc64le\n",
+		ClangTriple:        "powerpc64le-linux-gnu",
+		ClangOptions:       []string{"-O1", "-fno-pic"},
+		ParseAssembly:      parser{binary.LittleEndian}.parseAssembly,
+		ParseObjectDump:    parser{binary.LittleEndian}.parseObjectDump,
+		GenerateGoAssembly: parser{binary.LittleEndian}.generateGoAssembly,
+	})
+}
+
+// parser parses and generates the assembly of a target with its byte order.
+type parser struct {
+	order binary.ByteOrder
+}
+
+// generateCall emits a call to a private function. R12 holds the entry address
+// as required by the global entry point of the callee.
+func generateCall(callee string) string {
+	return fmt.Sprintf("\tMOVD $%s<>(SB), R12\n\tCALL %s<>(SB)\n", callee, callee)
+}
+
+// generateLine emits an instruction as a word, which the Go assembler writes in
+// the byte order of the target.
+func generateLine(line internal.Line) string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("\tWORD $0x%02x%02x%02x%02x",
+		line.Binary[3], line.Binary[2], line.Binary[1], line.Binary[0]))
+	builder.WriteString("\t// ")
+	builder.WriteString(line.Assembly)
+	builder.WriteByte('\n')
+	return builder.String()
+}
+
+func (p parser) parseAssembly(path string, globals []internal.Global) (map[string][]internal.Line, map[string]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func(file *os.File) {
+		if err = file.Close(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}(file)
+
+	var (
+		stackSizes    = make(map[string]int)
+		functions     = make(map[string][]internal.Line)
+		functionName  string
+		labelName     string
+		pendingAnchor string
+		data          = internal.DataCollector{Globals: globals}
+		anchors       = make(map[string]string)
+	)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if matches := numericLabelLine.FindStringSubmatch(strings.TrimSpace(line)); matches != nil {
+			line = "\t" + matches[1]
+		}
+		trimmed := strings.TrimSpace(line)
+		if ok, err := data.Collect(line, 8, p.order); err != nil {
+			return nil, nil, err
+		} else if ok {
+			if _, label := internal.ParseDataLabel(line); label && pendingAnchor != "" {
+				anchors[strings.ToLower(pendingAnchor)] = data.Name()
+				pendingAnchor = ""
+			}
+			continue
+		}
+		switch {
+		case anchorSetLine.MatchString(trimmed):
+			pendingAnchor = anchorSetLine.FindStringSubmatch(trimmed)[1]
+		case attributeLine.MatchString(line):
+			continue
+		case nameLine.MatchString(line):
+			name, _, _ := strings.Cut(line, ":")
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			functionName = name
+			functions[functionName] = make([]internal.Line, 0)
+			labelName = ""
+		case labelLine.MatchString(line):
+			labelName = strings.TrimPrefix(strings.Split(line, ":")[0], ".")
+			lines := functions[functionName]
+			if len(lines) > 0 && lines[len(lines)-1].Assembly == "" {
+				lines[len(lines)-1].Labels = append(lines[len(lines)-1].Labels, labelName)
+			} else {
+				functions[functionName] = append(functions[functionName], internal.Line{Labels: []string{labelName}})
+			}
+		case codeLine.MatchString(line):
+			asm := sanitizeAsm(line)
+			if labelName == "" {
+				functions[functionName] = append(functions[functionName], internal.Line{Assembly: asm})
+			} else {
+				lines := functions[functionName]
+				if len(lines) == 0 {
+					functions[functionName] = append(functions[functionName], internal.Line{Labels: []string{labelName}})
+					lines = functions[functionName]
+				}
+				lines[len(lines)-1].Assembly = asm
+				labelName = ""
+			}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	dataSymbols = data.Symbols
+	dataAnchors = anchors
+	return functions, stackSizes, nil
+}
+
+func sanitizeAsm(asm string) string {
+	asm = strings.TrimSpace(asm)
+	asm = strings.Split(asm, "#")[0]
+	asm = strings.Split(asm, "//")[0]
+	asm = strings.TrimSpace(asm)
+	return asm
+}
+
+// parseObjectDump reads the binaries of instructions, which are kept in
+// little-endian byte order whatever the byte order of the target.
+func (p parser) parseObjectDump(dump string, functions map[string][]internal.Line) error {
+	var functionName string
+	instructionIndexes := make(map[string]int)
+	for i, line := range strings.Split(dump, "\n") {
+		line = strings.TrimSpace(line)
+		if relocation, ok := internal.ParseRelocation(line); ok {
+			if index := instructionIndexes[functionName]; index > 0 {
+				lines := functions[functionName]
+				lines[index-1].Relocations = append(lines[index-1].Relocations, relocation)
+			}
+			continue
+		}
+		switch {
+		case symbolLine.MatchString(line):
+			functionName = strings.Split(line, "<")[1]
+			functionName = strings.Split(functionName, ">")[0]
+		case dataLine.MatchString(line):
+			lines, ok := functions[functionName]
+			if !ok {
+				continue
+			}
+			splits := strings.Fields(line)
+			if len(splits) < 6 {
+				continue
+			}
+			var bytes []byte
+			for _, s := range splits[1:5] {
+				decoded, err := hex.DecodeString(s)
+				if err != nil {
+					return fmt.Errorf("%d: invalid ppc64 instruction bytes %q: %w", i, s, err)
+				}
+				bytes = append(bytes, decoded...)
+			}
+			binary.LittleEndian.PutUint32(bytes, p.order.Uint32(bytes))
+			assembly := sanitizeAsm(strings.Join(splits[5:], " "))
+			if assembly == "" {
+				continue
+			}
+			index := instructionIndexes[functionName]
+			for index < len(lines) && lines[index].Assembly == "" {
+				index++
+			}
+			if index < len(lines) {
+				lines[index].Binary = string(bytes)
+				// Keep the compiler assembly text so relocatable references such as
+				// symbol@toc@ha/symbol@toc@l and call targets survive objdump,
+				// which prints them as 0.
+				_, isCall := callTarget(lines[index].Assembly)
+				_, isTailCall := tailCallTarget(lines[index].Assembly)
+				if !isCall && !isTailCall && !strings.Contains(lines[index].Assembly, "@toc@") {
+					lines[index].Assembly = assembly
+				}
+				functions[functionName] = lines
+				instructionIndexes[functionName] = index + 1
+			} else {
+				functions[functionName] = append(functions[functionName], internal.Line{
+					Assembly: assembly,
+					Binary:   string(bytes),
+				})
+				instructionIndexes[functionName] = len(functions[functionName])
+			}
+		}
+	}
+	return nil
+}
+
+func parameterSize(param internal.Parameter) int {
+	if param.Pointer {
+		return 8
+	}
+	return internal.SupportedTypes[param.Type]
+}
+
+func resultSize(typ string) int {
+	switch typ {
+	case "void":
+		return 0
+	case "_Bool":
+		return 1
+	case "float":
+		return 4
+	case "double", "int64_t", "long":
+		return 8
+	default:
+		_, _ = fmt.Fprintln(os.Stderr, "unsupported return type:", typ)
+		os.Exit(1)
+		return 0
+	}
+}
+
+func stackScratchSize(lines []internal.Line) int {
+	maxOffset := 0
+	for _, line := range lines {
+		matches := stackRefLine.FindAllStringSubmatch(line.Assembly, -1)
+		for _, match := range matches {
+			offset := 0
+			if _, err := fmt.Sscanf(match[1], "%d", &offset); err == nil && offset > maxOffset {
+				maxOffset = offset
+			}
+		}
+	}
+	if maxOffset%8 != 0 {
+		maxOffset += 8 - maxOffset%8
+	}
+	return maxOffset
+}
+
+func returnBranch(asm string) (string, bool) {
+	switch asm {
+	case "blr":
+		return "BR", true
+	case "beqlr", "beqlr 0":
+		return "BEQ", true
+	case "blelr", "blelr 0":
+		return "BLE", true
+	default:
+		return "", false
+	}
+}
+
+func asmRegisterName(num string) string {
+	return "R" + num
+}
+
+func mappedRegisterName(num string, replacement int, hasReplacement bool) string {
+	if hasReplacement && num == "30" {
+		return fmt.Sprintf("R%d", replacement)
+	}
+	return asmRegisterName(num)
+}
+
+func rewriteStackSpill(asm string, frameSize int, replacement int, hasReplacement bool) (string, bool) {
+	match := stackMoveLine.FindStringSubmatch(strings.ToLower(asm))
+	if len(match) != 4 {
+		return "", false
+	}
+	offset := 0
+	if _, err := fmt.Sscanf(match[3], "%d", &offset); err != nil {
+		return "", false
+	}
+	currentOffset := ppc64LinkageSize + frameSize + offset
+	reg := mappedRegisterName(match[2], replacement, hasReplacement)
+	switch match[1] {
+	case "std":
+		return fmt.Sprintf("\tMOVD %s, %d(R1)\n", reg, currentOffset), true
+	case "ld":
+		return fmt.Sprintf("\tMOVD %d(R1), %s\n", currentOffset, reg), true
+	case "stw":
+		return fmt.Sprintf("\tMOVW %s, %d(R1)\n", reg, currentOffset), true
+	case "lwz":
+		return fmt.Sprintf("\tMOVWZ %d(R1), %s\n", currentOffset, reg), true
+	default:
+		return "", false
+	}
+}
+
+// instructionRegisters returns the general purpose registers in the register
+// fields of an instruction, which the assembly text of clang does not prefix
+// with r.
+func instructionRegisters(line internal.Line) []int {
+	if len(line.Binary) != 4 {
+		return nil
+	}
+	word := binary.LittleEndian.Uint32([]byte(line.Binary))
+	fields, _ := registerFields(word)
+	registers := make([]int, 0, len(fields))
+	for _, shift := range fields {
+		registers = append(registers, int((word>>shift)&0x1f))
+	}
+	return registers
+}
+
+func usedRegisters(lines []internal.Line) map[int]struct{} {
+	registers := make(map[int]struct{})
+	for _, line := range lines {
+		for _, reg := range instructionRegisters(line) {
+			registers[reg] = struct{}{}
+		}
+		for _, match := range registerLine.FindAllStringSubmatch(strings.ToLower(line.Assembly), -1) {
+			reg := 0
+			if _, err := fmt.Sscanf(match[1], "%d", &reg); err == nil {
+				registers[reg] = struct{}{}
+			}
+		}
+	}
+	return registers
+}
+
+// checkRegisterFields fails on instructions whose register fields are not
+// known, since uses of R30 in them could not be found.
+func checkRegisterFields(functions []internal.Function) error {
+	for _, function := range functions {
+		for _, line := range function.Lines {
+			if len(line.Binary) != 4 {
+				continue
+			}
+			if _, ok := registerFields(binary.LittleEndian.Uint32([]byte(line.Binary))); !ok {
+				return fmt.Errorf("unknown registers of ppc64 instruction in %s: %s", function.Name, line.Assembly)
+			}
+		}
+	}
+	return nil
+}
+
+// R30 is the fixed g register in Go's ppc64 ABI, so machine code translated
+// from clang must not clobber it directly.
+func chooseReservedReplacement(lines []internal.Line) (int, bool) {
+	used := usedRegisters(lines)
+	if _, ok := used[30]; !ok {
+		return 0, false
+	}
+	for reg := 29; reg >= 14; reg-- {
+		if _, ok := used[reg]; !ok {
+			return reg, true
+		}
+	}
+	return 0, false
+}
+
+func patchInstructionWord(line internal.Line, word uint32, assembly string) internal.Line {
+	line.Assembly = assembly
+	line.Binary = string([]byte{byte(word), byte(word >> 8), byte(word >> 16), byte(word >> 24)})
+	return line
+}
+
+// rewriteReservedRegister replaces r30 with the replacement register in the
+// register fields of an instruction. It fails on instructions that mention r30
+// but are not known, or do not hold it in a general purpose register field.
+func rewriteReservedRegister(line internal.Line, replacement int) (internal.Line, bool) {
+	if len(line.Binary) != 4 {
+		return line, false
+	}
+	word := binary.LittleEndian.Uint32([]byte(line.Binary))
+	remapped, ok := remapRegister(word, 30, uint32(replacement))
+	if !ok || remapped == word {
+		return line, false
+	}
+	assembly := reservedRegisterLine.ReplaceAllString(strings.ToLower(line.Assembly), fmt.Sprintf("r%d", replacement))
+	return patchInstructionWord(line, remapped, assembly), true
+}
+
+// overflowLoadSizes are the sizes of the loads of overflow parameters.
+var overflowLoadSizes = map[string]int{"ld": 8, "lwz": 4, "lbz": 1}
+
+// rewriteOverflowLoad rewrites a load of a parameter from the parameter save
+// area into a load from the Go argument frame. Parameters are extended to the
+// 8 bytes of their doublewords, so a narrower load reads the low-order bytes,
+// which are at the end of the doubleword on big-endian targets.
+func (p parser) rewriteOverflowLoad(line internal.Line, offsetMap map[int]overflowParam, replacement int, hasReplacement bool) (string, bool) {
+	match := overflowLoadLine.FindStringSubmatch(strings.ToLower(strings.TrimSpace(line.Assembly)))
+	if len(match) != 4 {
+		return "", false
+	}
+	oldOffset := 0
+	if _, err := fmt.Sscanf(match[3], "%d", &oldOffset); err != nil {
+		return "", false
+	}
+	size := overflowLoadSizes[match[1]]
+	if p.order == binary.BigEndian {
+		oldOffset -= 8 - size
+	}
+	overflow, ok := offsetMap[oldOffset]
+	if !ok {
+		return "", false
+	}
+	reg := mappedRegisterName(match[2], replacement, hasReplacement)
+	switch {
+	case overflow.param.Type == "_Bool" && !overflow.param.Pointer:
+		return fmt.Sprintf("\tMOVBZ %s+%d(FP), %s\n", overflow.param.Name, overflow.offset, reg), true
+	case size != 8:
+		return "", false
+	case overflow.param.Pointer || overflow.param.Type == "int64_t" || overflow.param.Type == "long":
+		return fmt.Sprintf("\tMOVD %s+%d(FP), %s\n", overflow.param.Name, overflow.offset, reg), true
+	default:
+		return "", false
+	}
+}
+
+type overflowParam struct {
+	offset int
+	slot   int
+	param  internal.Parameter
+}
+
+// tocSymbol maps a lowercased TOC-relative symbol to the name of its data symbol.
+func tocSymbol(symbol string) string {
+	if mapped, ok := dataAnchors[symbol]; ok {
+		return mapped
+	}
+	name := internal.DataSymbolName(symbol)
+	for _, data := range dataSymbols {
+		if strings.EqualFold(data.Name, name) {
+			return data.Name
+		}
+	}
+	return name
+}
+
+// relocated reports whether a relocation of an instruction is resolved by its
+// rewrite into Go assembly.
+func relocated(lines []internal.Line, index int, relocation internal.Relocation) bool {
+	asm := lines[index].Assembly
+	switch relocation.Type {
+	case "R_PPC64_REL24", "R_PPC64_REL24_NOTOC":
+		_, isCall := callTarget(asm)
+		_, isTailCall := tailCallTarget(asm)
+		return isCall || isTailCall
+	case "R_PPC64_TOC16_HA":
+		_, ok := rewriteTOCHigh(lines[index], 0, false)
+		return ok
+	case "R_PPC64_TOC16_LO", "R_PPC64_TOC16_LO_DS":
+		_, err := patchTOCLow(lines[index])
+		return err == nil
+	case "R_PPC64_REL16_HA", "R_PPC64_REL16_LO":
+		// The TOC pointer set up in the global entry point is unused once every
+		// TOC-relative address load is rewritten.
+		return strings.HasPrefix(relocation.Symbol, ".TOC.")
+	}
+	return false
+}
+
+func rewriteTOCAddressLoad(lines []internal.Line, index int, replacement int, hasReplacement bool) (string, bool) {
+	if index+1 >= len(lines) {
+		return "", false
+	}
+	high := tocHighLine.FindStringSubmatch(strings.ToLower(strings.TrimSpace(lines[index].Assembly)))
+	low := tocLowLine.FindStringSubmatch(strings.ToLower(strings.TrimSpace(lines[index+1].Assembly)))
+	if len(high) != 4 || len(low) != 5 {
+		return "", false
+	}
+	if high[1] != low[1] || high[1] != low[2] || high[2] != low[3] || high[3] != low[4] {
+		return "", false
+	}
+	reg := mappedRegisterName(high[1], replacement, hasReplacement)
+	return fmt.Sprintf("\tMOVD $%s%s(SB), %s\n", internal.DataSymbolReference(tocSymbol(high[2]), dataSymbols), high[3], reg), true
+}
+
+// rewriteTOCHigh rewrites the high adjusted half of a TOC-relative address,
+// which is not paired with an addi of the low half, into a load of the address
+// of the Go symbol. The low half is replaced by the offset from the symbol in
+// the instructions that use it.
+func rewriteTOCHigh(line internal.Line, replacement int, hasReplacement bool) (string, bool) {
+	high := tocHighLine.FindStringSubmatch(strings.ToLower(strings.TrimSpace(line.Assembly)))
+	if high == nil {
+		return "", false
+	}
+	reg := mappedRegisterName(high[1], replacement, hasReplacement)
+	return fmt.Sprintf("\tMOVD $%s(SB), %s\n", internal.DataSymbolReference(tocSymbol(high[2]), dataSymbols), reg), true
+}
+
+// displacementAlignment returns the alignment of the displacement of a D, DS
+// or DQ-form instruction, the low bits of which hold an extended opcode.
+func displacementAlignment(word uint32) int64 {
+	switch word >> 26 {
+	case 57, 58, 62:
+		return 4
+	case 61:
+		if word&7 == 1 || word&7 == 5 {
+			// lxv and stxv
+			return 16
+		}
+		return 4
+	case 6:
+		return 16
+	}
+	return 1
+}
+
+// patchTOCLow returns an instruction that uses the low half of a TOC-relative
+// address, with the displacement replaced by the offset from the symbol.
+func patchTOCLow(line internal.Line) (internal.Line, error) {
+	for _, relocation := range line.Relocations {
+		if relocation.Type != "R_PPC64_TOC16_LO" && relocation.Type != "R_PPC64_TOC16_LO_DS" {
+			continue
+		}
+		matches := tocLowOperand.FindStringSubmatch(strings.ToLower(line.Assembly))
+		if matches == nil || len(line.Binary) != 4 {
+			return line, fmt.Errorf("missing @toc@l operand in %q", line.Assembly)
+		}
+		word := uint32(line.Binary[0]) | uint32(line.Binary[1])<<8 | uint32(line.Binary[2])<<16 | uint32(line.Binary[3])<<24
+		alignment := int64(1)
+		if relocation.Type == "R_PPC64_TOC16_LO_DS" {
+			alignment = displacementAlignment(word)
+		}
+		_, offset, ok := internal.SplitSymbolOffset(matches[1])
+		if !ok || offset%alignment != 0 || offset > 0x7fff {
+			return line, fmt.Errorf("unsupported operand %q", matches[1])
+		}
+		word = word&^uint32(0x10000-alignment) | uint32(offset)
+		return patchInstructionWord(line, word, line.Assembly), nil
+	}
+	return line, nil
+}
+
+func (p parser) generateGoAssembly(buildTags string, header string, goAssemblyPath string, functions []internal.Function) error {
+	var builder strings.Builder
+	builder.WriteString(buildTags)
+	builder.WriteString(header)
+	if err := internal.CheckDataAlignment(dataSymbols); err != nil {
+		return err
+	}
+	data, err := internal.GenerateDataSymbols(dataSymbols, p.order)
+	if err != nil {
+		return err
+	}
+	builder.WriteString(data)
+	locals, err := internal.LocalFunctions(functions, dataSymbols, callTarget, tailCallTarget)
+	if err != nil {
+		return err
+	}
+	if err = internal.CheckRelocations(functions, locals, relocated); err != nil {
+		return err
+	}
+	if err = checkRegisterFields(slices.Concat(functions, locals)); err != nil {
+		return err
+	}
+	for _, function := range functions {
+		if function.Local {
+			continue
+		}
+		var body strings.Builder
+		var overflowParams []overflowParam
+		registerSlot, fpRegisterCount, offset := 0, 0, 0
+		for _, param := range function.Parameters {
+			sz := parameterSize(param)
+			if offset%sz != 0 {
+				offset += sz - offset%sz
+			}
+			if !param.Pointer && (param.Type == "double" || param.Type == "float") {
+				if registerSlot < len(registers) && fpRegisterCount < len(fpRegisters) {
+					if param.Type == "double" {
+						body.WriteString(fmt.Sprintf("\tFMOVD %s+%d(FP), %s\n", param.Name, offset, fpRegisters[fpRegisterCount]))
+					} else {
+						body.WriteString(fmt.Sprintf("\tFMOVS %s+%d(FP), %s\n", param.Name, offset, fpRegisters[fpRegisterCount]))
+					}
+					fpRegisterCount++
+				} else if registerSlot >= len(registers) {
+					overflowParams = append(overflowParams, overflowParam{offset: offset, slot: registerSlot, param: param})
+				}
+			} else {
+				if registerSlot < len(registers) {
+					if param.Type == "_Bool" {
+						body.WriteString(fmt.Sprintf("\tMOVBZ %s+%d(FP), %s\n", param.Name, offset, registers[registerSlot]))
+					} else {
+						body.WriteString(fmt.Sprintf("\tMOVD %s+%d(FP), %s\n", param.Name, offset, registers[registerSlot]))
+					}
+				} else {
+					overflowParams = append(overflowParams, overflowParam{offset: offset, slot: registerSlot, param: param})
+				}
+			}
+			registerSlot++
+			offset += sz
+		}
+		if offset%8 != 0 {
+			offset += 8 - offset%8
+		}
+		resultOffset := offset
+		argSize := resultOffset + resultSize(function.Type)
+		replacement, hasReplacement := chooseReservedReplacement(function.Lines)
+		if hasReplacement && replacement == 0 {
+			return fmt.Errorf("ppc64 function %s uses r30 but no free callee-saved register is available", function.Name)
+		}
+		scratchSize := stackScratchSize(function.Lines)
+		frameSize := scratchSize
+		returnLabel := fmt.Sprintf("%s_return", function.Name)
+		overflowOffsetMap := make(map[int]overflowParam)
+
+		builder.WriteString(fmt.Sprintf("\nTEXT ·%v(SB), 4, $%d-%d\n", function.Name, frameSize, argSize))
+		builder.WriteString(body.String())
+		for _, overflow := range overflowParams {
+			originalOffset := 96 + (overflow.slot-len(registers))*8
+			overflowOffsetMap[originalOffset] = overflow
+		}
+		builder.WriteString(fmt.Sprintf("\tMOVD $·%s(SB), R12\n", function.Name))
+		for i := 0; i < len(function.Lines); i++ {
+			line := function.Lines[i]
+			if line.Assembly == "" {
+				continue
+			}
+			line, err := patchTOCLow(line)
+			if err != nil {
+				return err
+			}
+			if rewritten, ok := rewriteTOCAddressLoad(function.Lines, i, replacement, hasReplacement); ok {
+				builder.WriteString(rewritten)
+				i++
+			} else if rewritten, ok := rewriteTOCHigh(line, replacement, hasReplacement); ok {
+				builder.WriteString(rewritten)
+			} else if callee, ok := callTarget(line.Assembly); ok {
+				builder.WriteString(generateCall(callee))
+			} else if callee, ok := tailCallTarget(line.Assembly); ok {
+				// The callee must return here to store the result.
+				builder.WriteString(generateCall(callee))
+				builder.WriteString(fmt.Sprintf("\tBR %s\n", returnLabel))
+			} else if branch, ok := returnBranch(line.Assembly); ok {
+				builder.WriteString(fmt.Sprintf("\t%s %s\n", branch, returnLabel))
+			} else if rewritten, ok := p.rewriteOverflowLoad(line, overflowOffsetMap, replacement, hasReplacement); ok {
+				builder.WriteString(rewritten)
+			} else if rewritten, ok := rewriteStackSpill(line.Assembly, frameSize, replacement, hasReplacement); ok {
+				builder.WriteString(rewritten)
+			} else if hasReplacement {
+				if rewritten, ok := rewriteReservedRegister(line, replacement); ok {
+					builder.WriteString(generateLine(rewritten))
+				} else if slices.Contains(instructionRegisters(line), 30) || reservedRegisterLine.MatchString(strings.ToLower(line.Assembly)) {
+					return fmt.Errorf("unhandled ppc64 r30 instruction in %s: %s", function.Name, line.Assembly)
+				} else {
+					builder.WriteString(generateLine(line))
+				}
+			} else {
+				builder.WriteString(generateLine(line))
+			}
+		}
+		builder.WriteString(returnLabel)
+		builder.WriteString(":\n")
+		builder.WriteString("\tXOR R0, R0, R0\n")
+		if function.Type != "void" {
+			switch function.Type {
+			case "int64_t", "long":
+				builder.WriteString(fmt.Sprintf("\tMOVD R3, result+%d(FP)\n", resultOffset))
+			case "_Bool":
+				builder.WriteString(fmt.Sprintf("\tMOVB R3, result+%d(FP)\n", resultOffset))
+			case "double":
+				builder.WriteString(fmt.Sprintf("\tFMOVD F1, result+%d(FP)\n", resultOffset))
+			case "float":
+				builder.WriteString(fmt.Sprintf("\tFMOVS F1, result+%d(FP)\n", resultOffset))
+			default:
+				return fmt.Errorf("unsupported return type: %v", function.Type)
+			}
+		}
+		builder.WriteString("\tRET\n")
+	}
+	for _, function := range locals {
+		builder.WriteString(internal.LocalTextHeader(function.Name))
+		for i := 0; i < len(function.Lines); i++ {
+			line := function.Lines[i]
+			if line.Assembly == "" {
+				continue
+			}
+			line, err := patchTOCLow(line)
+			if err != nil {
+				return err
+			}
+			if slices.Contains(instructionRegisters(line), 30) || reservedRegisterLine.MatchString(strings.ToLower(line.Assembly)) {
+				// Remapping r30 would clobber a callee-saved register of the C caller.
+				return fmt.Errorf("unhandled ppc64 r30 instruction in %s: %s", function.Name, line.Assembly)
+			}
+			if rewritten, ok := rewriteTOCAddressLoad(function.Lines, i, 0, false); ok {
+				builder.WriteString(rewritten)
+				i++
+			} else if rewritten, ok := rewriteTOCHigh(line, 0, false); ok {
+				builder.WriteString(rewritten)
+			} else if callee, ok := callTarget(line.Assembly); ok {
+				builder.WriteString(generateCall(callee))
+			} else if callee, ok := tailCallTarget(line.Assembly); ok {
+				builder.WriteString(fmt.Sprintf("\tMOVD $%s<>(SB), R12\n\tJMP %s<>(SB)\n", callee, callee))
+			} else {
+				builder.WriteString(generateLine(line))
+			}
+		}
+	}
+
+	f, err := os.Create(goAssemblyPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		if err = f.Close(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}(f)
+	bytes, err := asmfmt.Format(strings.NewReader(builder.String()))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(bytes)
+	return err
+}
@@ -0,0 +1,65 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var registerOperand = regexp.MustCompile(`[%$]?\w+`)
+
+// ReservedRegister is a register that Go reserves but clang is free to use, as
+// named in the assembly of a target. If clang can keep it free, Option is the
+// clang option that does so.
+type ReservedRegister struct {
+	Names  []string
+	Option string
+}
+
+// ReservedRegisterOptions returns the clang options that keep the reserved
+// registers free.
+func ReservedRegisterOptions(registers []ReservedRegister) []string {
+	var options []string
+	for _, register := range registers {
+		if register.Option != "" {
+			options = append(options, register.Option)
+		}
+	}
+	return options
+}
+
+// CheckReservedRegisters returns an error for the first instruction of an
+// emitted function, exported or one of locals, that uses a reserved register.
+// Registers reserved by clang options are checked too, in case clang ignores
+// them, such as in inline assembly.
+func CheckReservedRegisters(functions, locals []Function, registers []ReservedRegister) error {
+	for _, function := range EmittedFunctions(functions, locals) {
+		for _, line := range function.Lines {
+			// The first word is the mnemonic.
+			words := registerOperand.FindAllString(strings.ToLower(line.Assembly), -1)
+			for _, operand := range words[min(len(words), 1):] {
+				for _, register := range registers {
+					if slices.Contains(register.Names, operand) {
+						return fmt.Errorf("function %s: reserved register %s is used in %q",
+							function.Name, operand, line.Assembly)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
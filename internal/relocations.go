@@ -0,0 +1,79 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var (
+	relocationLine = regexp.MustCompile(`^[0-9a-f]+:\s+(R_[A-Z0-9_]+)(?:/R_[A-Z0-9_]+)*(?:\s+(\S+))?$`)
+	symbolOffset   = regexp.MustCompile(`^([A-Za-z_.$][\w.$]*)(?:\+(0x[0-9a-fA-F]+|\d+))?$`)
+)
+
+// Relocation is a fixup that the object file applies to an instruction, as
+// printed by objdump -r after the instruction.
+type Relocation struct {
+	Type   string
+	Symbol string
+}
+
+// ParseRelocation parses a relocation line of objdump -r. A composite
+// relocation of mips64, such as R_MIPS_26/R_MIPS_NONE/R_MIPS_NONE, is parsed
+// as its first type.
+func ParseRelocation(line string) (Relocation, bool) {
+	matches := relocationLine.FindStringSubmatch(line)
+	if matches == nil {
+		return Relocation{}, false
+	}
+	return Relocation{Type: matches[1], Symbol: matches[2]}, true
+}
+
+// CheckRelocations returns an error for the first relocation of an emitted
+// function, exported or one of locals, that is not resolved by the rewrite of
+// the instruction it applies to. Whether an instruction is rewritten is decided
+// by the target from the relocation type, so that no instruction is emitted
+// with an unresolved fixup.
+func CheckRelocations(functions, locals []Function, rewritten func(lines []Line, index int, relocation Relocation) bool) error {
+	for _, function := range EmittedFunctions(functions, locals) {
+		for i, line := range function.Lines {
+			for _, relocation := range line.Relocations {
+				if !rewritten(function.Lines, i, relocation) {
+					return fmt.Errorf("function %s: unsupported relocation %s against %s in %q",
+						function.Name, relocation.Type, relocation.Symbol, line.Assembly)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// SplitSymbolOffset splits an assembly operand such as .LCPI0_0+16 into the
+// symbol and its offset.
+func SplitSymbolOffset(operand string) (string, int64, bool) {
+	matches := symbolOffset.FindStringSubmatch(operand)
+	if matches == nil {
+		return "", 0, false
+	}
+	if matches[2] == "" {
+		return matches[1], 0, true
+	}
+	offset, err := strconv.ParseInt(matches[2], 0, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return matches[1], offset, true
+}
@@ -0,0 +1,213 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package riscv64
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Major opcodes of 32-bit instructions.
+const (
+	opLoad     = 0x03
+	opLoadFP   = 0x07
+	opImm      = 0x13
+	opImm32    = 0x1b
+	opStore    = 0x23
+	opStoreFP  = 0x27
+	opReg      = 0x33
+	opLUI      = 0x37
+	opReg32    = 0x3b
+	opBranch   = 0x63
+	opJALR     = 0x67
+	opJAL      = 0x6f
+	unimp      = 0xc0001073
+	ebreak     = 0x00100073
+	registerSP = 2
+)
+
+func iType(imm int32, rs1, funct3, rd, opcode uint32) uint32 {
+	return uint32(imm)&0xfff<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+func sType(imm int32, rs2, rs1, funct3, opcode uint32) uint32 {
+	return uint32(imm)>>5&0x7f<<25 | rs2<<20 | rs1<<15 | funct3<<12 | uint32(imm)&0x1f<<7 | opcode
+}
+
+func rType(funct7, rs2, rs1, funct3, rd, opcode uint32) uint32 {
+	return funct7<<25 | rs2<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+func bType(imm int32, rs2, rs1, funct3 uint32) uint32 {
+	offset := uint32(imm)
+	return offset>>12&1<<31 | offset>>5&0x3f<<25 | rs2<<20 | rs1<<15 | funct3<<12 |
+		offset>>1&0xf<<8 | offset>>11&1<<7 | opBranch
+}
+
+func jType(imm int32, rd uint32) uint32 {
+	offset := uint32(imm)
+	return offset>>20&1<<31 | offset>>1&0x3ff<<21 | offset>>11&1<<20 | offset>>12&0xff<<12 | rd<<7 | opJAL
+}
+
+// signExtend extends the sign of the lowest n bits of value.
+func signExtend(value uint32, n uint) int32 {
+	return int32(value<<(32-n)) >> (32 - n)
+}
+
+// expandCompressed returns the 32-bit instruction that a compressed instruction
+// of RV64C expands to, so that it can be emitted as a WORD. Branches and jumps
+// are expanded too, although they are rewritten from their assembly.
+func expandCompressed(half uint16) (uint32, error) {
+	c := uint32(half)
+	bits := func(hi, lo uint) uint32 {
+		return c >> lo & (1<<(hi-lo+1) - 1)
+	}
+	rd := bits(11, 7)
+	rs2 := bits(6, 2)
+	// Registers x8-x15 in the 3-bit fields of CIW, CL, CS, CA and CB formats.
+	rdPrime := bits(4, 2) + 8
+	rs1Prime := bits(9, 7) + 8
+	imm6 := signExtend(bits(12, 12)<<5|bits(6, 2), 6)
+	switch quadrant, funct3 := c&3, bits(15, 13); {
+	case c == 0:
+		return unimp, nil
+	case quadrant == 0 && funct3 == 0: // c.addi4spn
+		imm := bits(12, 11)<<4 | bits(10, 7)<<6 | bits(6, 6)<<2 | bits(5, 5)<<3
+		if imm != 0 {
+			return iType(int32(imm), registerSP, 0, rdPrime, opImm), nil
+		}
+	case quadrant == 0 && (funct3 == 1 || funct3 == 3 || funct3 == 5 || funct3 == 7):
+		// c.fld, c.ld, c.fsd and c.sd
+		imm := int32(bits(12, 10)<<3 | bits(6, 5)<<6)
+		switch funct3 {
+		case 1:
+			return iType(imm, rs1Prime, 3, rdPrime, opLoadFP), nil
+		case 3:
+			return iType(imm, rs1Prime, 3, rdPrime, opLoad), nil
+		case 5:
+			return sType(imm, rdPrime, rs1Prime, 3, opStoreFP), nil
+		default:
+			return sType(imm, rdPrime, rs1Prime, 3, opStore), nil
+		}
+	case quadrant == 0 && (funct3 == 2 || funct3 == 6): // c.lw and c.sw
+		imm := int32(bits(12, 10)<<3 | bits(6, 6)<<2 | bits(5, 5)<<6)
+		if funct3 == 2 {
+			return iType(imm, rs1Prime, 2, rdPrime, opLoad), nil
+		}
+		return sType(imm, rdPrime, rs1Prime, 2, opStore), nil
+	case quadrant == 1 && funct3 == 0: // c.addi and c.nop
+		return iType(imm6, rd, 0, rd, opImm), nil
+	case quadrant == 1 && funct3 == 1: // c.addiw
+		if rd != 0 {
+			return iType(imm6, rd, 0, rd, opImm32), nil
+		}
+	case quadrant == 1 && funct3 == 2: // c.li
+		return iType(imm6, 0, 0, rd, opImm), nil
+	case quadrant == 1 && funct3 == 3 && rd == registerSP: // c.addi16sp
+		imm := signExtend(bits(12, 12)<<9|bits(6, 6)<<4|bits(5, 5)<<6|bits(4, 3)<<7|bits(2, 2)<<5, 10)
+		if imm != 0 {
+			return iType(imm, registerSP, 0, registerSP, opImm), nil
+		}
+	case quadrant == 1 && funct3 == 3: // c.lui
+		if imm6 != 0 {
+			return uint32(imm6)&0xfffff<<12 | rd<<7 | opLUI, nil
+		}
+	case quadrant == 1 && funct3 == 4:
+		shamt := int32(bits(12, 12)<<5 | bits(6, 2))
+		switch bits(11, 10) {
+		case 0: // c.srli
+			return iType(shamt, rs1Prime, 5, rs1Prime, opImm), nil
+		case 1: // c.srai
+			return iType(0x400|shamt, rs1Prime, 5, rs1Prime, opImm), nil
+		case 2: // c.andi
+			return iType(imm6, rs1Prime, 7, rs1Prime, opImm), nil
+		}
+		switch bits(12, 12)<<2 | bits(6, 5) {
+		case 0: // c.sub
+			return rType(0x20, rdPrime, rs1Prime, 0, rs1Prime, opReg), nil
+		case 1: // c.xor
+			return rType(0, rdPrime, rs1Prime, 4, rs1Prime, opReg), nil
+		case 2: // c.or
+			return rType(0, rdPrime, rs1Prime, 6, rs1Prime, opReg), nil
+		case 3: // c.and
+			return rType(0, rdPrime, rs1Prime, 7, rs1Prime, opReg), nil
+		case 4: // c.subw
+			return rType(0x20, rdPrime, rs1Prime, 0, rs1Prime, opReg32), nil
+		case 5: // c.addw
+			return rType(0, rdPrime, rs1Prime, 0, rs1Prime, opReg32), nil
+		}
+	case quadrant == 1 && funct3 == 5: // c.j
+		offset := bits(12, 12)<<11 | bits(11, 11)<<4 | bits(10, 9)<<8 | bits(8, 8)<<10 |
+			bits(7, 7)<<6 | bits(6, 6)<<7 | bits(5, 3)<<1 | bits(2, 2)<<5
+		return jType(signExtend(offset, 12), 0), nil
+	case quadrant == 1: // c.beqz and c.bnez
+		offset := bits(12, 12)<<8 | bits(11, 10)<<3 | bits(6, 5)<<6 | bits(4, 3)<<1 | bits(2, 2)<<5
+		return bType(signExtend(offset, 9), 0, rs1Prime, funct3-6), nil
+	case quadrant == 2 && funct3 == 0: // c.slli
+		return iType(int32(bits(12, 12)<<5|bits(6, 2)), rd, 1, rd, opImm), nil
+	case quadrant == 2 && (funct3 == 1 || funct3 == 3): // c.fldsp and c.ldsp
+		imm := int32(bits(12, 12)<<5 | bits(6, 5)<<3 | bits(4, 2)<<6)
+		if funct3 == 1 {
+			return iType(imm, registerSP, 3, rd, opLoadFP), nil
+		}
+		if rd != 0 {
+			return iType(imm, registerSP, 3, rd, opLoad), nil
+		}
+	case quadrant == 2 && funct3 == 2: // c.lwsp
+		if rd != 0 {
+			imm := int32(bits(12, 12)<<5 | bits(6, 4)<<2 | bits(3, 2)<<6)
+			return iType(imm, registerSP, 2, rd, opLoad), nil
+		}
+	case quadrant == 2 && funct3 == 4:
+		switch {
+		case bits(12, 12) == 0 && rs2 == 0 && rd != 0: // c.jr
+			return iType(0, rd, 0, 0, opJALR), nil
+		case bits(12, 12) == 0: // c.mv
+			return rType(0, rs2, 0, 0, rd, opReg), nil
+		case rd == 0 && rs2 == 0: // c.ebreak
+			return ebreak, nil
+		case rs2 == 0: // c.jalr
+			return iType(0, rd, 0, 1, opJALR), nil
+		default: // c.add
+			return rType(0, rs2, rd, 0, rd, opReg), nil
+		}
+	case quadrant == 2 && (funct3 == 5 || funct3 == 7): // c.fsdsp and c.sdsp
+		imm := int32(bits(12, 10)<<3 | bits(9, 7)<<6)
+		if funct3 == 5 {
+			return sType(imm, rs2, registerSP, 3, opStoreFP), nil
+		}
+		return sType(imm, rs2, registerSP, 3, opStore), nil
+	case quadrant == 2 && funct3 == 6: // c.swsp
+		imm := int32(bits(12, 9)<<2 | bits(8, 7)<<6)
+		return sType(imm, rs2, registerSP, 2, opStore), nil
+	}
+	return 0, fmt.Errorf("unsupported compressed instruction %04x", half)
+}
+
+// expandBinary returns the hexadecimal binary of an instruction, expanding it
+// to 32 bits if it is compressed.
+func expandBinary(binary string) (string, error) {
+	if len(binary) != 4 {
+		return binary, nil
+	}
+	half, err := strconv.ParseUint(binary, 16, 16)
+	if err != nil {
+		return "", err
+	}
+	word, err := expandCompressed(uint16(half))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08x", word), nil
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -35,14 +36,22 @@ var (
 
 	symbolLine = regexp.MustCompile(`^\w+\s+<\w+>:$`)
 	dataLine   = regexp.MustCompile(`^\w+:\s+\w+\s+.+$`)
-	auipcLine  = regexp.MustCompile(`^auipc\s+([a-z0-9]+), %pcrel_hi\(([A-Za-z_][A-Za-z0-9_]*)\)$`)
-	pcrelLine  = regexp.MustCompile(`^addi\s+([a-z0-9]+), ([a-z0-9]+), %pcrel_lo\(.+\)$`)
+	auipcLine  = regexp.MustCompile(`^auipc\s+([a-z0-9]+), %pcrel_hi\(([A-Za-z_.$][\w.$]*(?:\+\d+)?)\)$`)
+	luiLine    = regexp.MustCompile(`^lui\s+([a-z0-9]+), %hi\(([A-Za-z_.$][\w.$]*)(?:\+\d+)?\)$`)
+	loSymbol   = regexp.MustCompile(`%lo\(([A-Za-z_.$][\w.$]*(?:\+\d+)?)\)`)
+	callLine   = regexp.MustCompile(`^call\s+([A-Za-z_][A-Za-z0-9_]*)(?:@plt)?$`)
+	tailLine   = regexp.MustCompile(`^tail\s+([A-Za-z_][A-Za-z0-9_]*)(?:@plt)?$`)
 
 	registers   = []string{"A0", "A1", "A2", "A3", "A4", "A5", "A6", "A7"}
 	fpRegisters = []string{"FA0", "FA1", "FA2", "FA3", "FA4", "FA5", "FA6", "FA7"}
 	dataSymbols []internal.DataSymbol
 )
 
+var (
+	callTarget     = internal.MatchCallee(callLine)
+	tailCallTarget = internal.MatchCallee(tailLine)
+)
+
 func riscv64Register(reg string) string {
 	switch reg {
 	case "zero":
@@ -86,32 +95,96 @@ func riscv64Register(reg string) string {
 	}
 }
 
-func init() {
-	var prologue strings.Builder
-	prologue.WriteString("#define __riscv_vector 1\n")
-	for _, typeStr := range []string{"int64", "uint64", "int32", "uint32", "int16", "uint16", "int8", "uint8", "float64", "float32", "float16"} {
-		for i := 1; i <= 8; i *= 2 {
-			prologue.WriteString(fmt.Sprintf("typedef char v%sm%d_t;\n", typeStr, i))
-		}
-	}
+// reservedRegisters are the registers reserved by Go.
+var reservedRegisters = []internal.ReservedRegister{
+	// X27 points to the Go routine structure.
+	{Names: []string{"x27", "s11"}, Option: "-ffixed-x27"},
+	// X31 is the temporary register of the assembler, which may be used by the
+	// instructions that replace compiler-generated ones.
+	{Names: []string{"x31", "t6"}, Option: "-ffixed-x31"},
+}
 
+// Vector code is generated if the target, such as -march=rv64gcv, has the V
+// extension. Go treats vector registers and CSRs such as vl and vtype as
+// scratch, sets them before use and never preempts assembly, so they need not
+// be saved around the translated code.
+func init() {
 	internal.RegisterTarget("riscv64", internal.Target{
-		GOARCH:      "riscv64",
-		BuildTags:   "//go:build !noasm && riscv64\n",
-		ClangTriple: "riscv64-linux-gnu",
-		Prologue:    prologue.String(),
-		// X27 points to the Go routine structure.
-		ClangOptions:       []string{"-ffixed-x27"},
+		GOARCH:             "riscv64",
+		BuildTags:          "//go:build !noasm && riscv64\n",
+		ClangTriple:        "riscv64-linux-gnu",
+		ClangOptions:       internal.ReservedRegisterOptions(reservedRegisters),
 		ParseAssembly:      parseAssembly,
 		ParseObjectDump:    parseObjectDump,
 		GenerateGoAssembly: generateGoAssembly,
 	})
 }
 
+// relocated reports whether a relocation of an instruction is resolved by its
+// rewrite into Go assembly.
+func relocated(lines []internal.Line, index int, relocation internal.Relocation) bool {
+	asm := lines[index].Assembly
+	switch relocation.Type {
+	case "R_RISCV_CALL", "R_RISCV_CALL_PLT":
+		_, isCall := callTarget(asm)
+		_, isTailCall := tailCallTarget(asm)
+		return isCall || isTailCall
+	case "R_RISCV_BRANCH", "R_RISCV_JAL", "R_RISCV_RVC_BRANCH", "R_RISCV_RVC_JUMP":
+		return strings.HasPrefix(asm, "b") || strings.HasPrefix(asm, "j")
+	case "R_RISCV_PCREL_HI20":
+		return auipcLine.MatchString(asm)
+	case "R_RISCV_HI20":
+		return luiLine.MatchString(asm)
+	case "R_RISCV_PCREL_LO12_I", "R_RISCV_PCREL_LO12_S", "R_RISCV_LO12_I", "R_RISCV_LO12_S":
+		_, err := patchLo12(lines[index], relocation)
+		return err == nil
+	case "R_RISCV_RELAX", "R_RISCV_ALIGN":
+		// Hints for linker relaxation, which leave the instruction as it is.
+		return true
+	}
+	return false
+}
+
+// patchLo12 returns the binary of an instruction that adds the low 12 bits of
+// a symbol address, with the immediate replaced by the offset from the address
+// loaded by the rewritten AUIPC or LUI.
+func patchLo12(line internal.Line, relocation internal.Relocation) (string, error) {
+	var offset int64
+	if strings.HasPrefix(relocation.Type, "R_RISCV_PCREL_") {
+		// The AUIPC loads the address including the offset.
+		if !strings.Contains(line.Assembly, "%pcrel_lo(") {
+			return "", fmt.Errorf("missing %%pcrel_lo operand in %q", line.Assembly)
+		}
+	} else {
+		matches := loSymbol.FindStringSubmatch(line.Assembly)
+		if matches == nil {
+			return "", fmt.Errorf("missing %%lo operand in %q", line.Assembly)
+		}
+		var ok bool
+		if _, offset, ok = internal.SplitSymbolOffset(matches[1]); !ok || offset > 0x7ff {
+			return "", fmt.Errorf("unsupported operand %q", matches[1])
+		}
+	}
+	word, err := strconv.ParseUint(line.Binary, 16, 32)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(relocation.Type, "_S") {
+		word = word&^(0x7f<<25|0x1f<<7) | uint64(offset>>5)<<25 | uint64(offset&0x1f)<<7
+	} else {
+		word = word&^(0xfff<<20) | uint64(offset)<<20
+	}
+	return fmt.Sprintf("%08x", word), nil
+}
+
 func generateLine(line internal.Line) string {
 	var builder strings.Builder
 	builder.WriteString("\t")
-	if strings.HasPrefix(line.Assembly, "b") {
+	if callee, ok := callTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("CALL %s<>(SB)", callee))
+	} else if callee, ok := tailCallTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("JMP %s<>(SB)", callee))
+	} else if strings.HasPrefix(line.Assembly, "b") {
 		splits := strings.Split(line.Assembly, ".")
 		op := strings.TrimSpace(splits[0])
 		operand := splits[1]
@@ -121,16 +194,23 @@ func generateLine(line internal.Line) string {
 		label := splits[1][1:]
 		builder.WriteString(fmt.Sprintf("JMP %s\n", label))
 	} else if matches := auipcLine.FindStringSubmatch(line.Assembly); matches != nil {
-		builder.WriteString(fmt.Sprintf("MOV $%s<>(SB), %s", matches[2], riscv64Register(matches[1])))
-	} else if pcrelLine.MatchString(line.Assembly) {
-		// The preceding AUIPC is rewritten to load the full Go symbol address.
+		symbol, offset, _ := internal.SplitSymbolOffset(matches[2])
+		builder.WriteString(fmt.Sprintf("MOV $%s+%d(SB), %s", internal.DataSymbolReference(symbol, dataSymbols), offset, riscv64Register(matches[1])))
+	} else if matches := luiLine.FindStringSubmatch(line.Assembly); matches != nil {
+		builder.WriteString(fmt.Sprintf("MOV $%s(SB), %s", internal.DataSymbolReference(matches[2], dataSymbols), riscv64Register(matches[1])))
 	} else {
-		if len(line.Binary) == 8 {
-			builder.WriteString(fmt.Sprintf("WORD $0x%v", line.Binary))
-		} else {
-			_, _ = fmt.Fprintln(os.Stderr, "compressed instructions are not supported.")
-			os.Exit(1)
+		binary := line.Binary
+		for _, relocation := range line.Relocations {
+			if strings.Contains(relocation.Type, "LO12") {
+				patched, err := patchLo12(line, relocation)
+				if err != nil {
+					_, _ = fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				binary = patched
+			}
 		}
+		builder.WriteString(fmt.Sprintf("WORD $0x%v", binary))
 		builder.WriteString("\t// ")
 		builder.WriteString(line.Assembly)
 	}
@@ -138,7 +218,7 @@ func generateLine(line internal.Line) string {
 	return builder.String()
 }
 
-func parseAssembly(path string) (map[string][]internal.Line, map[string]int, error) {
+func parseAssembly(path string, globals []internal.Global) (map[string][]internal.Line, map[string]int, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, nil, err
@@ -155,35 +235,25 @@ func parseAssembly(path string) (map[string][]internal.Line, map[string]int, err
 		functions    = make(map[string][]internal.Line)
 		functionName string
 		labelName    string
-		dataName     string
-		dataSection  bool
-		data         []internal.DataSymbol
+		data         = internal.DataCollector{Globals: globals}
 	)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, ".section") {
-			dataSection = strings.Contains(trimmed, ".rodata") || strings.Contains(trimmed, ".data")
-		}
-		if parsed, ok, err := internal.ParseDataDirective(line); err != nil {
+		if ok, err := data.Collect(line, 8, binary.LittleEndian); err != nil {
 			return nil, nil, err
-		} else if ok && dataName != "" {
-			data = append(data, internal.DataSymbol{Name: dataName, Data: parsed})
-			dataName = ""
-		} else if attributeLine.MatchString(line) {
+		} else if ok {
+			continue
+		}
+		if attributeLine.MatchString(line) {
 			continue
 		} else if nameLine.MatchString(line) {
 			name, _, _ := strings.Cut(line, ":")
 			if strings.HasPrefix(name, ".") {
 				continue
 			}
-			if dataSection {
-				dataName = name
-			} else {
-				functionName = name
-				functions[functionName] = make([]internal.Line, 0)
-			}
+			functionName = name
+			functions[functionName] = make([]internal.Line, 0)
 		} else if labelLine.MatchString(line) {
 			labelName = strings.Split(line, ":")[0]
 			labelName = labelName[1:]
@@ -211,7 +281,7 @@ func parseAssembly(path string) (map[string][]internal.Line, map[string]int, err
 	if err = scanner.Err(); err != nil {
 		return nil, nil, err
 	}
-	dataSymbols = data
+	dataSymbols = data.Symbols
 	return functions, stackSizes, nil
 }
 
@@ -219,13 +289,20 @@ func parseObjectDump(dump string, functions map[string][]internal.Line) error {
 	var (
 		functionName string
 		lineNumber   int
+		pendingCall  bool
 	)
 	for i, line := range strings.Split(dump, "\n") {
 		line = strings.TrimSpace(line)
-		if symbolLine.MatchString(line) {
+		if relocation, ok := internal.ParseRelocation(line); ok {
+			if lineNumber > 0 {
+				lines := functions[functionName]
+				lines[lineNumber-1].Relocations = append(lines[lineNumber-1].Relocations, relocation)
+			}
+		} else if symbolLine.MatchString(line) {
 			functionName = strings.Split(line, "<")[1]
 			functionName = strings.Split(functionName, ">")[0]
 			lineNumber = 0
+			pendingCall = false
 		} else if dataLine.MatchString(line) {
 			data := strings.Split(line, ":")[1]
 			data = strings.TrimSpace(data)
@@ -242,10 +319,25 @@ func parseObjectDump(dump string, functions map[string][]internal.Line) error {
 				}
 				binary = s
 			}
+			binary, err := expandBinary(binary)
+			if err != nil {
+				return fmt.Errorf("%d: %w", i, err)
+			}
+			if pendingCall {
+				// The call and tail pseudo-instructions expand to AUIPC and JALR.
+				functions[functionName][lineNumber-1].Binary += binary
+				pendingCall = false
+				continue
+			}
 			if lineNumber >= len(functions[functionName]) {
 				return fmt.Errorf("%d: unexpected objectdump line: %s", i, line)
 			}
 			functions[functionName][lineNumber].Binary = binary
+			if strings.HasPrefix(assembly, "auipc") {
+				_, isCall := callTarget(functions[functionName][lineNumber].Assembly)
+				_, isTailCall := tailCallTarget(functions[functionName][lineNumber].Assembly)
+				pendingCall = isCall || isTailCall
+			}
 			lineNumber++
 		}
 	}
@@ -257,8 +349,28 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 	var builder strings.Builder
 	builder.WriteString(buildTags)
 	builder.WriteString(header)
-	builder.WriteString(internal.GenerateDataSymbols(dataSymbols, binary.LittleEndian))
+	if err := internal.CheckDataAlignment(dataSymbols); err != nil {
+		return err
+	}
+	data, err := internal.GenerateDataSymbols(dataSymbols, binary.LittleEndian)
+	if err != nil {
+		return err
+	}
+	builder.WriteString(data)
+	locals, err := internal.LocalFunctions(functions, dataSymbols, callTarget, tailCallTarget)
+	if err != nil {
+		return err
+	}
+	if err = internal.CheckRelocations(functions, locals, relocated); err != nil {
+		return err
+	}
+	if err = internal.CheckReservedRegisters(functions, locals, reservedRegisters); err != nil {
+		return err
+	}
 	for _, function := range functions {
+		if function.Local {
+			continue
+		}
 		returnSize := 0
 		if function.Type != "void" {
 			returnSize += 8
@@ -331,7 +443,12 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 				builder.WriteString(label)
 				builder.WriteString(":\n")
 			}
-			if line.Assembly == "ret" {
+			callee, isTailCall := tailCallTarget(line.Assembly)
+			if isTailCall {
+				// The callee must return here to store the result.
+				builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+			}
+			if isTailCall || line.Assembly == "ret" {
 				if frameSize > 0 {
 					builder.WriteString(fmt.Sprintf("\tADDI %d, SP, SP\n", frameSize))
 				}
@@ -355,6 +472,16 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 			}
 		}
 	}
+	for _, function := range locals {
+		builder.WriteString(internal.LocalTextHeader(function.Name))
+		for _, line := range function.Lines {
+			for _, label := range line.Labels {
+				builder.WriteString(label)
+				builder.WriteString(":\n")
+			}
+			builder.WriteString(generateLine(line))
+		}
+	}
 
 	// write file
 	f, err := os.Create(goAssemblyPath)
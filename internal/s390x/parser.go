@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode"
@@ -39,28 +40,97 @@ var (
 
 	symbolLine = regexp.MustCompile(`^\w+\s+<\w+>:$`)
 	dataLine   = regexp.MustCompile(`^\w+:\s+\w+\s+.+$`)
-	larlLine   = regexp.MustCompile(`^larl\s+%r([0-9]+), ([A-Za-z_][A-Za-z0-9_]*)$`)
+	larlLine   = regexp.MustCompile(`^larl\s+%r([0-9]+), ([A-Za-z_.$][\w.$]*(?:\+\d+)?)$`)
+	loadLine   = regexp.MustCompile(`^(lgrl|lgfrl|llgfrl)\s+%r([0-9]+), ([A-Za-z_.$][\w.$]*(?:\+\d+)?)$`)
+	storeLine  = regexp.MustCompile(`^(stgrl|strl)\s+%r([0-9]+), ([A-Za-z_.$][\w.$]*(?:\+\d+)?)$`)
+	callLine   = regexp.MustCompile(`^brasl\s+%r14, ([A-Za-z_][A-Za-z0-9_]*)(?:@PLT)?$`)
+	tailLine   = regexp.MustCompile(`^jg\s+([A-Za-z_][A-Za-z0-9_]*)(?:@PLT)?$`)
+	basrLine   = regexp.MustCompile(`^basr\s+%r14, %r[0-9]+$`)
+	returnLine = regexp.MustCompile(`^br\s+%r14$`)
+	stackLine  = regexp.MustCompile(`^(?:aghi|agfi)\s+%r15, -(\d+)$|^lay\s+%r15, -(\d+)\(%r15\)$`)
 
-	registers   = []string{"R2", "R3", "R4", "R5", "R6"}
-	fpRegisters = []string{"F0", "F2", "F4", "F6"}
-	dataSymbols []internal.DataSymbol
+	// The ELF ABI passes five integers, four floats and eight vectors in
+	// registers, and the rest on the stack.
+	registers       = []string{"R2", "R3", "R4", "R5", "R6"}
+	fpRegisters     = []string{"F0", "F2", "F4", "F6"}
+	vectorRegisters = []string{"V24", "V26", "V28", "V30", "V25", "V27", "V29", "V31"}
+	dataSymbols     []internal.DataSymbol
 )
 
+var (
+	callTarget     = internal.MatchCallee(callLine)
+	tailCallTarget = internal.MatchCallee(tailLine)
+)
+
+// reservedRegisters are the registers reserved by Go, which clang cannot be
+// told to keep free.
+var reservedRegisters = []internal.ReservedRegister{
+	// R13 points to the Go routine structure. R0 is allocated by Go like other
+	// registers, since it only reads as zero in address calculations.
+	{Names: []string{"%r13"}},
+}
+
 func init() {
 	internal.RegisterTarget("s390x", internal.Target{
 		GOARCH:             "s390x",
 		BuildTags:          "//go:build !noasm && s390x\n",
 		ClangTriple:        "s390x-linux-gnu",
+		VectorArguments:    true,
 		ParseAssembly:      parseAssembly,
 		ParseObjectDump:    parseObjectDump,
 		GenerateGoAssembly: generateGoAssembly,
 	})
 }
 
+// relativeMnemonics maps the relative long loads and stores to Go assembler
+// mnemonics.
+var relativeMnemonics = map[string]string{
+	"lgrl":   "MOVD",
+	"lgfrl":  "MOVW",
+	"llgfrl": "MOVWZ",
+	"stgrl":  "MOVD",
+	"strl":   "MOVW",
+}
+
+// relocated reports whether a relocation of an instruction is resolved by its
+// rewrite into Go assembly.
+func relocated(lines []internal.Line, index int, relocation internal.Relocation) bool {
+	asm := lines[index].Assembly
+	_, isCall := callTarget(asm)
+	_, isTailCall := tailCallTarget(asm)
+	switch relocation.Type {
+	case "R_390_PC32DBL":
+		return isCall || isTailCall || larlLine.MatchString(asm) || loadLine.MatchString(asm) ||
+			storeLine.MatchString(asm)
+	case "R_390_PLT32DBL":
+		return isCall || isTailCall
+	}
+	return false
+}
+
 func generateLine(line internal.Line) string {
 	var builder strings.Builder
+	if callee, ok := callTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+		return builder.String()
+	}
+	if callee, ok := tailCallTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("\tJMP %s<>(SB)\n", callee))
+		return builder.String()
+	}
 	if matches := larlLine.FindStringSubmatch(line.Assembly); matches != nil {
-		builder.WriteString(fmt.Sprintf("\tMOVD $%s<>(SB), R%s\n", matches[2], matches[1]))
+		symbol, offset, _ := internal.SplitSymbolOffset(matches[2])
+		builder.WriteString(fmt.Sprintf("\tMOVD $%s+%d(SB), R%s\n", internal.DataSymbolReference(symbol, dataSymbols), offset, matches[1]))
+		return builder.String()
+	}
+	if matches := loadLine.FindStringSubmatch(line.Assembly); matches != nil {
+		symbol, offset, _ := internal.SplitSymbolOffset(matches[3])
+		builder.WriteString(fmt.Sprintf("\t%s %s+%d(SB), R%s\n", relativeMnemonics[matches[1]], internal.DataSymbolReference(symbol, dataSymbols), offset, matches[2]))
+		return builder.String()
+	}
+	if matches := storeLine.FindStringSubmatch(line.Assembly); matches != nil {
+		symbol, offset, _ := internal.SplitSymbolOffset(matches[3])
+		builder.WriteString(fmt.Sprintf("\t%s R%s, %s+%d(SB)\n", relativeMnemonics[matches[1]], matches[2], internal.DataSymbolReference(symbol, dataSymbols), offset))
 		return builder.String()
 	}
 	builder.WriteString("\t")
@@ -76,7 +146,7 @@ func generateLine(line internal.Line) string {
 	return builder.String()
 }
 
-func parseAssembly(path string) (map[string][]internal.Line, map[string]int, error) {
+func parseAssembly(path string, globals []internal.Global) (map[string][]internal.Line, map[string]int, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, nil, err
@@ -93,31 +163,17 @@ func parseAssembly(path string) (map[string][]internal.Line, map[string]int, err
 		functions    = make(map[string][]internal.Line)
 		functionName string
 		labelName    string
-		dataName     string
-		dataSection  bool
-		data         []internal.DataSymbol
+		data         = internal.DataCollector{Globals: globals}
 	)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, ".section") {
-			dataSection = strings.Contains(trimmed, ".rodata") || strings.Contains(trimmed, ".data")
+		if ok, err := data.Collect(line, 8, binary.BigEndian); err != nil {
+			return nil, nil, err
+		} else if ok {
+			continue
 		}
 		switch {
-		case func() bool {
-			parsed, ok, err := internal.ParseDataDirective(line)
-			if err != nil {
-				return false
-			}
-			if ok && dataName != "" {
-				data = append(data, internal.DataSymbol{Name: dataName, Data: parsed})
-				dataName = ""
-				return true
-			}
-			return false
-		}():
-			continue
 		case attributeLine.MatchString(line):
 			continue
 		case nameLine.MatchString(line):
@@ -125,13 +181,9 @@ func parseAssembly(path string) (map[string][]internal.Line, map[string]int, err
 			if strings.HasPrefix(name, ".") {
 				continue
 			}
-			if dataSection {
-				dataName = name
-			} else {
-				functionName = name
-				functions[functionName] = make([]internal.Line, 0)
-				labelName = ""
-			}
+			functionName = name
+			functions[functionName] = make([]internal.Line, 0)
+			labelName = ""
 		case labelLine.MatchString(line):
 			labelName = strings.TrimPrefix(strings.Split(line, ":")[0], ".")
 			lines := functions[functionName]
@@ -142,15 +194,9 @@ func parseAssembly(path string) (map[string][]internal.Line, map[string]int, err
 			}
 		case codeLine.MatchString(line):
 			asm := sanitizeAsm(line)
-			if strings.HasPrefix(asm, "aghi") && strings.Contains(asm, "%r15") {
-				fields := strings.FieldsFunc(asm, func(r rune) bool {
-					return unicode.IsSpace(r) || r == ','
-				})
-				if len(fields) >= 3 && strings.HasPrefix(fields[2], "-") {
-					if size, convErr := strconv.Atoi(strings.TrimPrefix(fields[2], "-")); convErr == nil {
-						stackSizes[functionName] = size
-					}
-				}
+			if matches := stackLine.FindStringSubmatch(asm); matches != nil {
+				size, _ := strconv.Atoi(matches[1] + matches[2])
+				stackSizes[functionName] += size
 			}
 			if labelName == "" {
 				functions[functionName] = append(functions[functionName], internal.Line{Assembly: asm})
@@ -169,7 +215,7 @@ func parseAssembly(path string) (map[string][]internal.Line, map[string]int, err
 	if err = scanner.Err(); err != nil {
 		return nil, nil, err
 	}
-	dataSymbols = data
+	dataSymbols = data.Symbols
 	return functions, stackSizes, nil
 }
 
@@ -187,6 +233,13 @@ func parseObjectDump(dump string, functions map[string][]internal.Line) error {
 	)
 	for i, line := range strings.Split(dump, "\n") {
 		line = strings.TrimSpace(line)
+		if relocation, ok := internal.ParseRelocation(line); ok {
+			if lineNumber > 0 {
+				lines := functions[functionName]
+				lines[lineNumber-1].Relocations = append(lines[lineNumber-1].Relocations, relocation)
+			}
+			continue
+		}
 		switch {
 		case symbolLine.MatchString(line):
 			functionName = strings.Split(line, "<")[1]
@@ -230,9 +283,82 @@ func parameterSize(param internal.Parameter) int {
 	if param.Pointer {
 		return 8
 	}
+	if _, ok := internal.VectorTypes[param.Type]; ok {
+		return 16
+	}
 	return internal.SupportedTypes[param.Type]
 }
 
+// parameterAlignment returns the alignment of a parameter in the arguments of
+// Go, which is the size of the elements for vectors.
+func parameterAlignment(param internal.Parameter) int {
+	if goType, ok := internal.VectorTypes[param.Type]; ok && !param.Pointer {
+		length, _ := strconv.Atoi(goType[1:strings.IndexByte(goType, ']')])
+		return 16 / length
+	}
+	return parameterSize(param)
+}
+
+// localStackSize returns the stack that the frames of C take below an exported
+// function, which is that of its deepest chain of calls to local functions.
+// Functions whose addresses are stored in data symbols may be called by any
+// indirect call.
+func localStackSize(function internal.Function, locals []internal.Function) (int, error) {
+	graph := callGraph{locals: locals, sizes: make(map[string]int)}
+	for _, symbol := range dataSymbols {
+		for _, pointer := range symbol.Pointers {
+			graph.indirect = append(graph.indirect, pointer.Symbol)
+		}
+	}
+	size, err := graph.stackSize(function, nil)
+	if err != nil {
+		return 0, err
+	}
+	if size%8 != 0 {
+		size += 8 - size%8
+	}
+	return size, nil
+}
+
+// callGraph is the graph of calls between the local functions.
+type callGraph struct {
+	locals   []internal.Function
+	indirect []string
+	sizes    map[string]int
+}
+
+// stackSize returns the stack that a function and its deepest chain of callees
+// take. The callers of the function are in path, so that recursion, the depth
+// of which is unknown, is reported.
+func (g callGraph) stackSize(function internal.Function, path []string) (int, error) {
+	if size, ok := g.sizes[function.Name]; ok {
+		return size, nil
+	}
+	if start := slices.Index(path, function.Name); start >= 0 {
+		return 0, fmt.Errorf("function %s: unsupported recursion %s -> %s",
+			path[0], strings.Join(path[start:], " -> "), function.Name)
+	}
+	path = append(path, function.Name)
+	callees := internal.Callees(function, callTarget, tailCallTarget)
+	if slices.ContainsFunc(function.Lines, func(line internal.Line) bool { return basrLine.MatchString(line.Assembly) }) {
+		callees = append(callees, g.indirect...)
+	}
+	deepest := 0
+	for _, callee := range callees {
+		index := slices.IndexFunc(g.locals, func(local internal.Function) bool { return local.Name == callee })
+		if index < 0 {
+			continue
+		}
+		size, err := g.stackSize(g.locals[index], path)
+		if err != nil {
+			return 0, err
+		}
+		deepest = max(deepest, size)
+	}
+	g.sizes[function.Name] = function.StackSize + deepest
+	return g.sizes[function.Name], nil
+}
+
 func resultSize(typ string) int {
 	switch typ {
 	case "void":
@@ -244,6 +370,9 @@ func resultSize(typ string) int {
 	case "double", "int64_t", "long":
 		return 8
 	default:
+		if _, ok := internal.VectorTypes[typ]; ok {
+			return 16
+		}
 		_, _ = fmt.Fprintln(os.Stderr, "unsupported return type:", typ)
 		os.Exit(1)
 		return 0
@@ -288,17 +417,43 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 	var builder strings.Builder
 	builder.WriteString(buildTags)
 	builder.WriteString(header)
-	builder.WriteString(internal.GenerateDataSymbols(dataSymbols, binary.BigEndian))
+	if err := internal.CheckDataAlignment(dataSymbols); err != nil {
+		return err
+	}
+	data, err := internal.GenerateDataSymbols(dataSymbols, binary.BigEndian)
+	if err != nil {
+		return err
+	}
+	builder.WriteString(data)
+	locals, err := internal.LocalFunctions(functions, dataSymbols, callTarget, tailCallTarget)
+	if err != nil {
+		return err
+	}
+	if err = internal.CheckRelocations(functions, locals, relocated); err != nil {
+		return err
+	}
+	if err = internal.CheckReservedRegisters(functions, locals, reservedRegisters); err != nil {
+		return err
+	}
 	for _, function := range functions {
+		if function.Local {
+			continue
+		}
 		var body strings.Builder
-		registerCount, fpRegisterCount, offset := 0, 0, 0
+		registerCount, fpRegisterCount, vectorCount, offset := 0, 0, 0, 0
 		var stack []lo.Tuple2[int, internal.Parameter]
 		for _, param := range function.Parameters {
-			sz := parameterSize(param)
-			if offset%sz != 0 {
-				offset += sz - offset%sz
+			if align := parameterAlignment(param); offset%align != 0 {
+				offset += align - offset%align
 			}
-			if !param.Pointer && (param.Type == "double" || param.Type == "float") {
+			if _, ok := internal.VectorTypes[param.Type]; ok && !param.Pointer {
+				if vectorCount == len(vectorRegisters) {
+					return fmt.Errorf("function %s: more than %d vector arguments are not supported",
+						function.Name, len(vectorRegisters))
+				}
+				body.WriteString(fmt.Sprintf("\tVL %s+%d(FP), %s\n", param.Name, offset, vectorRegisters[vectorCount]))
+				vectorCount++
+			} else if !param.Pointer && (param.Type == "double" || param.Type == "float") {
 				if fpRegisterCount < len(fpRegisters) {
 					if param.Type == "double" {
 						body.WriteString(fmt.Sprintf("\tFMOVD %s+%d(FP), %s\n", param.Name, offset, fpRegisters[fpRegisterCount]))
@@ -326,7 +481,7 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 					stack = append(stack, lo.Tuple2[int, internal.Parameter]{A: offset, B: param})
 				}
 			}
-			offset += sz
+			offset += parameterSize(param)
 		}
 		if offset%8 != 0 {
 			offset += 8 - offset%8
@@ -334,23 +489,48 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 		resultOffset := offset
 		argSize := resultOffset + resultSize(function.Type)
 
-		frameSize := callerStackAreaSize + len(stack)*8
+		// The frames of C, which grow with spilled vector and floating-point
+		// registers, are kept in the Go frame above its return address, so that
+		// they are covered by the check for stack overflow. R15 is raised to
+		// their top, where the register save area of the callee starts.
+		stackSize, err := localStackSize(function, locals)
+		if err != nil {
+			return err
+		}
+		shift := 0
+		if stackSize > 0 {
+			shift = stackSize + 8
+		}
+		frameSize := callerStackAreaSize + len(stack)*8 + max(shift-8, 0)
 		builder.WriteString(fmt.Sprintf("\nTEXT ·%v(SB), $%d-%d\n",
 			function.Name, frameSize, argSize))
 		builder.WriteString(body.String())
 		if len(stack) > 0 {
 			for i := range stack {
-				slotBase := callerStackAreaSize + i*8
+				slotBase := shift + callerStackAreaSize + i*8
 				emitStoreFromFP(&builder, stack[i].B, stack[i].A, slotBase+stackSlotValueOffset(stack[i].B))
 			}
 		}
+		if shift > 0 {
+			builder.WriteString(fmt.Sprintf("\tADD $%d, R15\n", shift))
+		}
 		for _, line := range function.Lines {
 			for _, label := range line.Labels {
 				builder.WriteString(label)
 				builder.WriteString(":\n")
 			}
-			if strings.HasPrefix(line.Assembly, "br") && strings.Contains(line.Assembly, "%r14") {
-				if function.Type != "void" {
+			callee, isTailCall := tailCallTarget(line.Assembly)
+			if isTailCall {
+				// The callee must return here to store the result.
+				builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+			}
+			if isTailCall || returnLine.MatchString(line.Assembly) {
+				if shift > 0 {
+					builder.WriteString(fmt.Sprintf("\tADD $-%d, R15\n", shift))
+				}
+				if _, ok := internal.VectorTypes[function.Type]; ok {
+					builder.WriteString(fmt.Sprintf("\tVST V24, result+%d(FP)\n", resultOffset))
+				} else if function.Type != "void" {
 					switch function.Type {
 					case "int64_t", "long":
 						builder.WriteString(fmt.Sprintf("\tMOVD R2, result+%d(FP)\n", resultOffset))
@@ -370,6 +550,16 @@ func generateGoAssembly(buildTags string, header string, goAssemblyPath string,
 			}
 		}
 	}
+	for _, function := range locals {
+		builder.WriteString(internal.LocalTextHeader(function.Name))
+		for _, line := range function.Lines {
+			for _, label := range line.Labels {
+				builder.WriteString(label)
+				builder.WriteString(":\n")
+			}
+			builder.WriteString(generateLine(line))
+		}
+	}
 
 	f, err := os.Create(goAssemblyPath)
 	if err != nil {
@@ -18,17 +18,46 @@ import (
 	"sync"
 )
 
+// Compiler is the C compiler that generates the machine code of a target.
+type Compiler int
+
+const (
+	// Clang is run as $CLANG, or clang, with the target triple.
+	Clang Compiler = iota
+	// GCC is run as the cross compiler named after the target triple.
+	GCC
+)
+
 type Target struct {
-	GOARCH             string
-	BuildTags          string
-	ClangTriple        string
-	Prologue           string
-	ClangOptions       []string
-	ParseAssembly      func(string) (map[string][]Line, map[string]int, error)
+	GOARCH      string
+	BuildTags   string
+	ClangTriple string
+	// Toolchain is the triple of the GNU cross toolchain, such as objdump, if
+	// it differs from ClangTriple.
+	Toolchain    string
+	Compiler     Compiler
+	ClangOptions []string
+	// HWCaps are the optional CPU features of the target, which are checked by
+	// a generated Supported function if the source is compiled for them.
+	HWCaps []HWCap
+	// VectorArguments reports whether exported functions take and return the
+	// vector types of C, which are held by Go arrays.
+	VectorArguments bool
+	// LongSize is the size of long in bytes on targets where it is not 8.
+	LongSize           int
+	ParseAssembly      func(string, []Global) (map[string][]Line, map[string]int, error)
 	ParseObjectDump    func(string, map[string][]Line) error
 	GenerateGoAssembly func(string, string, string, []Function) error
 }
 
+// ToolchainTriple returns the triple of the GNU cross toolchain.
+func (t Target) ToolchainTriple() string {
+	if t.Toolchain != "" {
+		return t.Toolchain
+	}
+	return t.ClangTriple
+}
+
 var (
 	targetMu sync.RWMutex
 	targets  = make(map[string]Target)
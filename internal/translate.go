@@ -43,6 +43,9 @@ type TranslateUnit struct {
 	Go         string
 	Package    string
 	Options    []string
+	Includes   []string
+	Exports    []string
+	HWCaps     []HWCap
 	Offset     int
 	Target     Target
 }
@@ -63,8 +66,9 @@ func NewTranslateUnit(source string, outputDir string, target Target, options ..
 	}
 }
 
-// ParseSource parses the C source file and extracts function declarations.
-func (t *TranslateUnit) ParseSource() ([]Function, error) {
+// ParseSource parses the C source file and extracts function declarations and
+// the types of exported globals.
+func (t *TranslateUnit) ParseSource() ([]Function, []Global, error) {
 	clangPath := GetClangPath()
 	args := []string{"-target", t.Target.ClangTriple}
 	args = append(args, t.Target.ClangOptions...)
@@ -73,33 +77,56 @@ func (t *TranslateUnit) ParseSource() ([]Function, error) {
 
 	output, err := RunCommand(clangPath, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse source file %v: %w", t.Source, err)
+		return nil, nil, fmt.Errorf("failed to parse source file %v: %w", t.Source, err)
 	}
 
 	var root clangASTNode
 	if err := json.Unmarshal([]byte(output), &root); err != nil {
-		return nil, fmt.Errorf("failed to decode clang AST for %v: %w", t.Source, err)
+		return nil, nil, fmt.Errorf("failed to decode clang AST for %v: %w", t.Source, err)
 	}
 
 	functions := make([]Function, 0)
 	if err := t.collectClangFunctions(&root, &functions); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	sort.Slice(functions, func(i, j int) bool {
 		return functions[i].Position < functions[j].Position
 	})
-	return functions, nil
+	globals, err := t.collectClangGlobals(&root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return functions, globals, nil
 }
 
-func (t *TranslateUnit) GenerateGoStubs(functions []Function) error {
+func (t *TranslateUnit) GenerateGoStubs(functions []Function, globals []Global) error {
 	var builder strings.Builder
 	builder.WriteString(t.Target.BuildTags)
 	builder.WriteString(t.Header())
 	builder.WriteString(fmt.Sprintf("package %v\n", t.Package))
+	var imports []string
+	if len(t.HWCaps) > 0 {
+		imports = append(imports, "encoding/binary", "os")
+	}
 	if HasPointer(functions) {
-		builder.WriteString("\nimport \"unsafe\"\n")
+		imports = append(imports, "unsafe")
+	}
+	if len(imports) == 1 {
+		builder.WriteString(fmt.Sprintf("\nimport %q\n", imports[0]))
+	} else if len(imports) > 1 {
+		builder.WriteString("\nimport (\n")
+		for _, path := range imports {
+			builder.WriteString(fmt.Sprintf("\t%q\n", path))
+		}
+		builder.WriteString(")\n")
+	}
+	for _, global := range globals {
+		builder.WriteString(fmt.Sprintf("\nvar %s %s\n", global.Name, global.Type))
 	}
 	for _, function := range functions {
+		if function.Local {
+			continue
+		}
 		builder.WriteString("\n//go:noescape\n")
 		builder.WriteString("func ")
 		builder.WriteString(function.Name)
@@ -109,9 +136,9 @@ func (t *TranslateUnit) GenerateGoStubs(functions []Function) error {
 				builder.WriteString(", ")
 			}
 			builder.WriteString(param.Name)
-			if i+1 == len(function.Parameters) || function.Parameters[i+1].String() != param.String() {
+			if i+1 == len(function.Parameters) || function.Parameters[i+1].GoType(t.Target) != param.GoType(t.Target) {
 				builder.WriteRune(' ')
-				builder.WriteString(param.String())
+				builder.WriteString(param.GoType(t.Target))
 			}
 		}
 		builder.WriteRune(')')
@@ -124,13 +151,20 @@ func (t *TranslateUnit) GenerateGoStubs(functions []Function) error {
 			case "float":
 				builder.WriteString(" (result float32)")
 			case "int64_t", "long":
-				builder.WriteString(" (result int64)")
+				builder.WriteString(fmt.Sprintf(" (result %s)", ParameterType{Type: function.Type}.GoType(t.Target)))
 			default:
-				return fmt.Errorf("unsupported return type: %v", function.Type)
+				goType, ok := VectorTypes[function.Type]
+				if !ok || !t.Target.VectorArguments {
+					return fmt.Errorf("unsupported return type: %v", function.Type)
+				}
+				builder.WriteString(fmt.Sprintf(" (result %s)", goType))
 			}
 		}
 		builder.WriteRune('\n')
 	}
+	if len(t.HWCaps) > 0 {
+		builder.WriteString(GenerateSupported(t.HWCaps))
+	}
 
 	f, err := os.Create(t.Go)
 	if err != nil {
@@ -147,49 +181,53 @@ func (t *TranslateUnit) GenerateGoStubs(functions []Function) error {
 }
 
 func (t *TranslateUnit) compile(args ...string) error {
-	if t.Target.GOARCH == "ppc64le" {
+	switch t.Target.Compiler {
+	case GCC:
 		args = append(args, "-finline-limit=1000",
-			"-fno-asynchronous-unwind-tables", "-fno-exceptions", "-fno-builtin",
-			"-ffixed-r0", "-ffixed-r30")
-	} else {
+			"-fno-asynchronous-unwind-tables", "-fno-exceptions", "-fno-builtin")
+	default:
+		args = append([]string{"-target", t.Target.ClangTriple}, args...)
 		args = append(args, "-mllvm", "-inline-threshold=1000",
 			"-fno-asynchronous-unwind-tables", "-fno-exceptions", "-fno-rtti", "-fno-builtin")
 	}
 	args = append(args, t.Target.ClangOptions...)
-	clangPath := GetClangPath()
-	var err error
-	if t.Target.GOARCH == "ppc64le" {
-		_, err = RunCommand("powerpc64le-linux-gnu-gcc", append([]string{"-S", "-c", t.Source, "-o", t.Assembly}, args...)...)
-	} else {
-		_, err = RunCommand(clangPath, append([]string{"-S", "-target", t.Target.ClangTriple, "-c", t.Source, "-o", t.Assembly}, args...)...)
+	for _, include := range t.Includes {
+		args = append(args, "-include", include)
 	}
-	if err != nil {
+	compilerPath := GetCompilerPath(t.Target)
+	if _, err := RunCommand(compilerPath, append([]string{"-S", "-c", t.Source, "-o", t.Assembly}, args...)...); err != nil {
 		return err
 	}
-	if t.Target.GOARCH == "ppc64le" {
-		_, err = RunCommand("powerpc64le-linux-gnu-gcc", append([]string{"-c", t.Assembly, "-o", t.Object}, args...)...)
-	} else {
-		_, err = RunCommand(clangPath, append([]string{"-target", t.Target.ClangTriple, "-c", t.Assembly, "-o", t.Object}, args...)...)
-	}
+	_, err := RunCommand(compilerPath, append([]string{"-c", t.Assembly, "-o", t.Object}, args...)...)
 	return err
 }
 
 func (t *TranslateUnit) Translate() error {
-	functions, err := t.ParseSource()
+	functions, globals, err := t.ParseSource()
 	if err != nil {
 		return err
 	}
-	if err = t.GenerateGoStubs(functions); err != nil {
+	if t.HWCaps, err = t.EnabledHWCaps(); err != nil {
+		return err
+	}
+	if err = t.GenerateGoStubs(functions, globals); err != nil {
 		return err
 	}
 	if err = t.compile(t.Options...); err != nil {
 		return err
 	}
-	assembly, stackSizes, err := t.Target.ParseAssembly(t.Assembly)
+	libcFunctions, err := t.linkLibc()
+	if err != nil {
+		return err
+	}
+	for _, name := range libcFunctions {
+		functions = append(functions, Function{Name: name, Local: true})
+	}
+	assembly, stackSizes, err := t.Target.ParseAssembly(t.Assembly, globals)
 	if err != nil {
 		return err
 	}
-	dump, err := RunCommand(GetObjdumpPath(t.Target), "-d", t.Object, "--insn-width", "16")
+	dump, err := RunCommand(GetObjdumpPath(t.Target), "-d", "-r", t.Object, "--insn-width", "16")
 	if err != nil {
 		return err
 	}
@@ -207,7 +245,11 @@ func (t *TranslateUnit) Header() string {
 	var builder strings.Builder
 	builder.WriteString("// Code generated by GoAT. DO NOT EDIT.\n")
 	builder.WriteString("// versions:\n")
-	builder.WriteString(fmt.Sprintf("// \tclang   %s\n", FetchVersion(GetClangPath())))
+	if t.Target.Compiler == GCC {
+		builder.WriteString(fmt.Sprintf("// \tgcc     %s\n", FetchVersion(GetCompilerPath(t.Target))))
+	} else {
+		builder.WriteString(fmt.Sprintf("// \tclang   %s\n", FetchVersion(GetClangPath())))
+	}
 	builder.WriteString(fmt.Sprintf("// \tobjdump %s\n", FetchVersion(GetObjdumpPath(t.Target))))
 	builder.WriteString("// flags:")
 	for _, option := range t.Options {
@@ -279,6 +321,15 @@ func GetClangPath() string {
 	return "clang"
 }
 
+// GetCompilerPath returns the path to the compiler that generates the machine
+// code of the target.
+func GetCompilerPath(target Target) string {
+	if target.Compiler == GCC {
+		return target.ToolchainTriple() + "-gcc"
+	}
+	return GetClangPath()
+}
+
 // GetObjdumpPath returns the path to the target-specific objdump executable.
 // If OBJDUMP is set, it must resolve to an executable. Otherwise, GoAT requires
 // the canonical cross-toolchain objdump for the target architecture.
@@ -290,5 +341,5 @@ func GetObjdumpPath(target Target) string {
 	if target.GOARCH == runtime.GOARCH {
 		return "objdump"
 	}
-	return target.ClangTriple + "-objdump"
+	return target.ToolchainTriple() + "-objdump"
 }
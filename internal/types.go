@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -39,21 +40,34 @@ func (p ParameterType) String() string {
 	case "float":
 		return "float32"
 	default:
+		if goType, ok := VectorTypes[p.Type]; ok {
+			return goType
+		}
 		_, _ = fmt.Fprintln(os.Stderr, "unsupported param type:", p.Type)
 		os.Exit(1)
 		return ""
 	}
 }
 
+// GoType returns the Go type of the parameter on the target, where long is held
+// by int32 if the target has a 4-byte long.
+func (p ParameterType) GoType(target Target) string {
+	if p.Type == "long" && !p.Pointer && target.LongSize == 4 {
+		return "int32"
+	}
+	return p.String()
+}
+
 type Parameter struct {
 	Name string
 	ParameterType
 }
 
 type Line struct {
-	Labels   []string
-	Assembly string
-	Binary   string
+	Labels      []string
+	Assembly    string
+	Binary      string
+	Relocations []Relocation
 }
 
 type Function struct {
@@ -63,15 +77,70 @@ type Function struct {
 	Parameters []Parameter
 	Lines      []Line
 	StackSize  int
+	// Local functions are static helpers and bundled library functions that
+	// are only called from translated code, so no Go declarations are
+	// generated for them.
+	Local bool
 }
 
+// Global is a C global variable exported as a Go package-level variable.
+type Global struct {
+	Name string
+	Type string
+}
+
+// globalTypes maps C scalar types to the Go types of exported globals.
+var globalTypes = map[string]string{
+	"_Bool":              "bool",
+	"char":               "byte",
+	"signed char":        "int8",
+	"unsigned char":      "uint8",
+	"short":              "int16",
+	"unsigned short":     "uint16",
+	"int":                "int32",
+	"unsigned int":       "uint32",
+	"unsigned":           "uint32",
+	"long":               "int64",
+	"unsigned long":      "uint64",
+	"long long":          "int64",
+	"unsigned long long": "uint64",
+	"int8_t":             "int8",
+	"uint8_t":            "uint8",
+	"int16_t":            "int16",
+	"uint16_t":           "uint16",
+	"int32_t":            "int32",
+	"uint32_t":           "uint32",
+	"int64_t":            "int64",
+	"uint64_t":           "uint64",
+	"float":              "float32",
+	"double":             "float64",
+}
+
+// VectorTypes maps the vector types of C, as printed by clang, to the Go arrays
+// that hold them, for targets with Target.VectorArguments.
+var VectorTypes = map[string]string{
+	"__vector signed char":        "[16]int8",
+	"__vector unsigned char":      "[16]uint8",
+	"__vector short":              "[8]int16",
+	"__vector unsigned short":     "[8]uint16",
+	"__vector int":                "[4]int32",
+	"__vector unsigned int":       "[4]uint32",
+	"__vector long long":          "[2]int64",
+	"__vector unsigned long long": "[2]uint64",
+	"__vector float":              "[4]float32",
+	"__vector double":             "[2]float64",
+}
+
+var arrayDimensions = regexp.MustCompile(`^(\[\d+\])+$`)
+
 type clangASTNode struct {
-	Kind   string         `json:"kind"`
-	Name   string         `json:"name"`
-	Type   *clangASTType  `json:"type"`
-	Loc    clangASTLoc    `json:"loc"`
-	Inline bool           `json:"inline"`
-	Inner  []clangASTNode `json:"inner"`
+	Kind         string         `json:"kind"`
+	Name         string         `json:"name"`
+	Type         *clangASTType  `json:"type"`
+	Loc          clangASTLoc    `json:"loc"`
+	Inline       bool           `json:"inline"`
+	StorageClass string         `json:"storageClass"`
+	Inner        []clangASTNode `json:"inner"`
 }
 
 type clangASTType struct {
@@ -102,8 +171,66 @@ func (t *TranslateUnit) collectClangFunctions(node *clangASTNode, functions *[]F
 	return nil
 }
 
+// collectClangGlobals looks up the exported globals among the variables
+// defined at file scope.
+func (t *TranslateUnit) collectClangGlobals(root *clangASTNode) ([]Global, error) {
+	globals := make([]Global, 0, len(t.Exports))
+	for _, name := range t.Exports {
+		var node *clangASTNode
+		for i := range root.Inner {
+			child := &root.Inner[i]
+			if child.Kind == "VarDecl" && child.Name == name && child.StorageClass != "extern" && child.Type != nil {
+				node = child
+			}
+		}
+		if node == nil {
+			return nil, fmt.Errorf("global %v is not defined in %v", name, t.Source)
+		}
+		globalType, err := clangGlobalType(node.Type.QualType, t.Target.LongSize)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v:1: error: %w", t.Source, node.Loc.Line+t.Offset, err)
+		}
+		globals = append(globals, Global{Name: name, Type: globalType})
+	}
+	return globals, nil
+}
+
+// clangGlobalType converts the type of a global, which is a scalar or an array
+// of scalars, into a Go type. Long is 32-bit if longSize is 4.
+func clangGlobalType(qualType string, longSize int) (string, error) {
+	var dimensions string
+	if i := strings.IndexRune(qualType, '['); i != -1 {
+		qualType, dimensions = qualType[:i], strings.ReplaceAll(qualType[i:], " ", "")
+		if !arrayDimensions.MatchString(dimensions) {
+			return "", fmt.Errorf("unsupported global type: %v%v", qualType, dimensions)
+		}
+	}
+	scalarType, isPointer := parseClangQualType(qualType)
+	goType, ok := globalTypes[scalarType]
+	if !ok || isPointer {
+		return "", fmt.Errorf("unsupported global type: %v", strings.TrimSpace(qualType))
+	}
+	if longSize == 4 && (scalarType == "long" || scalarType == "unsigned long") {
+		goType = strings.Replace(goType, "64", "32", 1)
+	}
+	return dimensions + goType, nil
+}
+
 func (t *TranslateUnit) convertClangFunction(node *clangASTNode) (Function, bool, error) {
-	if !t.isSourceFunction(node) || node.Inline {
+	if !t.isSourceFunction(node) {
+		return Function{}, false, nil
+	}
+	if node.StorageClass == "static" {
+		// Static helpers, inline or not, follow the C calling convention, so
+		// their parameter types are not restricted to the types supported by
+		// Go stubs. Helpers inlined everywhere have no assembly and are dropped.
+		return Function{
+			Name:     node.Name,
+			Position: node.Loc.Line,
+			Local:    true,
+		}, true, nil
+	}
+	if node.Inline {
 		return Function{}, false, nil
 	}
 
@@ -117,7 +244,8 @@ func (t *TranslateUnit) convertClangFunction(node *clangASTNode) (Function, bool
 			return Function{}, false, fmt.Errorf("missing parameter type for function %v", node.Name)
 		}
 		paramType, isPointer := parseClangQualType(child.Type.QualType)
-		if _, ok := SupportedTypes[paramType]; !ok && !isPointer {
+		_, isVector := VectorTypes[paramType]
+		if _, ok := SupportedTypes[paramType]; !ok && !isPointer && !(isVector && t.Target.VectorArguments) {
 			line := child.Loc.Line
 			if line == 0 {
 				line = node.Loc.Line
@@ -0,0 +1,693 @@
+// Copyright 2022 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package x86
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/gorse-io/goat/internal"
+	"github.com/klauspost/asmfmt"
+)
+
+var (
+	attributeLine = regexp.MustCompile(`^\s+\..+$`)
+	nameLine      = regexp.MustCompile(`^\w+:.*$`)
+	labelLine     = regexp.MustCompile(`^\.\w+_\d+:.*$`)
+	codeLine      = regexp.MustCompile(`^\s+\w+.+$`)
+
+	symbolLine    = regexp.MustCompile(`^\w+\s+<\w+>:$`)
+	dataLine      = regexp.MustCompile(`^\w+:\s+\w+\s+.+$`)
+	callLine      = regexp.MustCompile(`^calll?\s+([A-Za-z_][A-Za-z0-9_]*)(?:@PLT)?$`)
+	tailLine      = regexp.MustCompile(`^(j[a-z]+)\s+([A-Za-z_][A-Za-z0-9_]*)(?:@PLT)?$`)
+	retLine       = regexp.MustCompile(`^retl?$`)
+	memoryOperand = regexp.MustCompile(`^([^(]*)(?:\((%\w+)?(?:,(%\w+)(?:,([1248]))?)?\))?$`)
+
+	dataSymbols []internal.DataSymbol
+)
+
+var (
+	callTarget     = internal.MatchCallee(callLine)
+	tailCallTarget = internal.MatchCallee(tailLine)
+)
+
+// absoluteMnemonics maps AT&T mnemonics to Go assembler mnemonics where they
+// differ by more than case.
+var absoluteMnemonics = map[string]string{
+	"movzbw":    "MOVBWZX",
+	"movzbl":    "MOVBLZX",
+	"movzwl":    "MOVWLZX",
+	"movsbw":    "MOVBWSX",
+	"movsbl":    "MOVBLSX",
+	"movswl":    "MOVWLSX",
+	"movd":      "MOVL",
+	"movdqa":    "MOVO",
+	"movdqu":    "MOVOU",
+	"cvtsi2ssl": "CVTSL2SS",
+	"cvtsi2sdl": "CVTSL2SD",
+	"cvtss2si":  "CVTSS2SL",
+	"cvtsd2si":  "CVTSD2SL",
+	"cvttss2si": "CVTTSS2SL",
+	"cvttsd2si": "CVTTSD2SL",
+}
+
+// x87Mnemonics maps AT&T mnemonics of x87 instructions with a memory operand to
+// Go assembler mnemonics, which name the top of the stack explicitly.
+var x87Mnemonics = map[string]string{
+	"flds":   "FMOVF",
+	"fldl":   "FMOVD",
+	"fadds":  "FADDF",
+	"faddl":  "FADDD",
+	"fsubs":  "FSUBF",
+	"fsubl":  "FSUBD",
+	"fsubrs": "FSUBRF",
+	"fsubrl": "FSUBRD",
+	"fmuls":  "FMULF",
+	"fmull":  "FMULD",
+	"fdivs":  "FDIVF",
+	"fdivl":  "FDIVD",
+	"fdivrs": "FDIVRF",
+	"fdivrl": "FDIVRD",
+}
+
+// The code is compiled without position independence, so that data is
+// addressed absolutely, and without jump tables. The stack is realigned, since
+// Go aligns it to 4 bytes only. The sse2 target matches GO386=sse2, and the
+// softfloat target keeps floating point on the x87 unit as GO386=softfloat
+// expects of processors without SSE2.
+func init() {
+	internal.RegisterTarget("386", internal.Target{
+		GOARCH:      "386",
+		BuildTags:   "//go:build !noasm && 386 && 386.sse2\n",
+		ClangTriple: "i686-linux-gnu",
+		LongSize:    4,
+		ClangOptions: []string{"-msse2", "-mfpmath=sse", "-mstackrealign",
+			"-fno-pic", "-fno-jump-tables"},
+		ParseAssembly:      parseAssembly,
+		ParseObjectDump:    parseObjectDump,
+		GenerateGoAssembly: generateGoAssembly,
+	})
+	internal.RegisterTarget("386.softfloat", internal.Target{
+		GOARCH:      "386",
+		BuildTags:   "//go:build !noasm && 386 && 386.softfloat\n",
+		ClangTriple: "i686-linux-gnu",
+		LongSize:    4,
+		ClangOptions: []string{"-mno-sse", "-mno-mmx", "-mstackrealign",
+			"-fno-pic", "-fno-jump-tables"},
+		ParseAssembly:      parseAssembly,
+		ParseObjectDump:    parseObjectDump,
+		GenerateGoAssembly: generateGoAssembly,
+	})
+}
+
+func goRegister(reg string) string {
+	reg = strings.TrimPrefix(reg, "%")
+	switch {
+	case strings.HasPrefix(reg, "xmm"):
+		return "X" + strings.TrimPrefix(reg, "xmm")
+	case reg == "st":
+		return "F0"
+	case strings.HasPrefix(reg, "st("):
+		return "F" + strings.Trim(reg[2:], "()")
+	}
+	switch reg {
+	case "eax", "ax", "al":
+		return "AX"
+	case "ebx", "bx", "bl":
+		return "BX"
+	case "ecx", "cx", "cl":
+		return "CX"
+	case "edx", "dx", "dl":
+		return "DX"
+	case "esi", "si":
+		return "SI"
+	case "edi", "di":
+		return "DI"
+	case "ebp", "bp":
+		return "BP"
+	case "esp", "sp":
+		return "SP"
+	default:
+		return strings.ToUpper(reg)
+	}
+}
+
+// tailCall returns the jump mnemonic and the callee of a tail call. The
+// optimizer also folds tail calls into conditional jumps.
+func tailCall(asm string) (string, string, bool) {
+	if matches := tailLine.FindStringSubmatch(asm); matches != nil {
+		return matches[1], matches[2], true
+	}
+	return "", "", false
+}
+
+// absolute reports whether an instruction addresses data absolutely.
+func absolute(line internal.Line) bool {
+	for _, relocation := range line.Relocations {
+		if relocation.Type == "R_386_32" {
+			return true
+		}
+	}
+	return false
+}
+
+// relocated reports whether a relocation of an instruction is resolved by its
+// rewrite into Go assembly.
+func relocated(lines []internal.Line, index int, relocation internal.Relocation) bool {
+	asm := lines[index].Assembly
+	switch relocation.Type {
+	case "R_386_PC32", "R_386_PLT32":
+		_, isCall := callTarget(asm)
+		_, isTailCall := tailCallTarget(asm)
+		return isCall || isTailCall
+	case "R_386_32":
+		_, err := absoluteInstruction(asm)
+		return err == nil
+	}
+	return false
+}
+
+// splitOperands splits the operands of an instruction at the commas outside of
+// memory operands.
+func splitOperands(operands string) []string {
+	var (
+		splits []string
+		depth  int
+		start  int
+	)
+	for i, r := range operands {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				splits = append(splits, strings.TrimSpace(operands[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(splits, strings.TrimSpace(operands[start:]))
+}
+
+// symbolReference returns the Go assembler operand that addresses a symbol.
+func symbolReference(symbol string, offset int64) string {
+	if offset != 0 {
+		return fmt.Sprintf("%s%+d(SB)", internal.DataSymbolReference(symbol, dataSymbols), offset)
+	}
+	return fmt.Sprintf("%s(SB)", internal.DataSymbolReference(symbol, dataSymbols))
+}
+
+// goOperand rewrites an AT&T operand into Go assembler syntax, where a symbol is
+// addressed relative to SB and a register indexes it.
+func goOperand(operand string) (string, error) {
+	switch {
+	case strings.HasPrefix(operand, "%"):
+		return goRegister(operand), nil
+	case strings.HasPrefix(operand, "$"):
+		if symbol, offset, ok := internal.SplitSymbolOffset(operand[1:]); ok {
+			return "$" + symbolReference(symbol, offset), nil
+		}
+		return operand, nil
+	}
+	matches := memoryOperand.FindStringSubmatch(operand)
+	if matches == nil {
+		return "", fmt.Errorf("unsupported operand: %s", operand)
+	}
+	displacement, base, index, scale := matches[1], matches[2], matches[3], matches[4]
+	if scale == "" {
+		scale = "1"
+	}
+	symbol, offset, ok := internal.SplitSymbolOffset(displacement)
+	if !ok {
+		var builder strings.Builder
+		builder.WriteString(displacement)
+		builder.WriteString(fmt.Sprintf("(%s)", goRegister(base)))
+		if index != "" {
+			builder.WriteString(fmt.Sprintf("(%s*%s)", goRegister(index), scale))
+		}
+		return builder.String(), nil
+	}
+	reference := symbolReference(symbol, offset)
+	switch {
+	case base != "" && index != "":
+		return "", fmt.Errorf("unsupported operand: %s", operand)
+	case base != "":
+		return fmt.Sprintf("%s(%s*1)", reference, goRegister(base)), nil
+	case index != "":
+		return fmt.Sprintf("%s(%s*%s)", reference, goRegister(index), scale), nil
+	}
+	return reference, nil
+}
+
+// absoluteInstruction rewrites an instruction that addresses data absolutely
+// into Go assembler syntax, so that the Go linker resolves the address.
+// Operands keep the AT&T order, which the Go assembler shares, except for
+// integer comparisons.
+func absoluteInstruction(asm string) (string, error) {
+	asm, _, _ = strings.Cut(asm, "#")
+	asm = strings.TrimSpace(asm)
+	mnemonic, operandList, _ := strings.Cut(asm, "\t")
+	if strings.Contains(mnemonic, " ") {
+		mnemonic, operandList, _ = strings.Cut(asm, " ")
+	}
+	if strings.HasPrefix(mnemonic, "push") || strings.HasPrefix(mnemonic, "pop") {
+		// The Go assembler would adjust the frame for them.
+		return "", fmt.Errorf("unsupported absolute operand: %s", asm)
+	}
+	var operands []string
+	for _, operand := range splitOperands(operandList) {
+		converted, err := goOperand(operand)
+		if err != nil {
+			return "", fmt.Errorf("unsupported absolute operand: %s", asm)
+		}
+		operands = append(operands, converted)
+	}
+
+	op, ok := absoluteMnemonics[mnemonic]
+	switch {
+	case ok:
+	case x87Mnemonics[mnemonic] != "" && len(operands) == 1:
+		op = x87Mnemonics[mnemonic]
+		operands = append(operands, "F0")
+	case strings.HasPrefix(mnemonic, "f"):
+		return "", fmt.Errorf("unsupported absolute operand: %s", asm)
+	case strings.HasPrefix(mnemonic, "cmp") && len(mnemonic) == 4 && strings.ContainsAny(mnemonic[3:], "bwl"):
+		op = strings.ToUpper(mnemonic)
+		operands[0], operands[1] = operands[1], operands[0]
+	case strings.HasPrefix(mnemonic, "cmp") || strings.HasPrefix(mnemonic, "vcmp"):
+		// Comparison predicates are encoded differently by the Go assembler.
+		return "", fmt.Errorf("unsupported absolute operand: %s", asm)
+	default:
+		op = strings.ToUpper(mnemonic)
+	}
+	return fmt.Sprintf("%s %s", op, strings.Join(operands, ", ")), nil
+}
+
+func generateLine(line internal.Line) (string, error) {
+	var builder strings.Builder
+	builder.WriteString("\t")
+	if callee, ok := callTarget(line.Assembly); ok {
+		builder.WriteString(fmt.Sprintf("CALL %s<>(SB)", callee))
+	} else if strings.HasPrefix(line.Assembly, "j") && strings.Contains(line.Assembly, "*") {
+		return "", fmt.Errorf("unsupported indirect jump: %s", line.Assembly)
+	} else if strings.HasPrefix(line.Assembly, "j") {
+		splits := strings.Split(line.Assembly, ".")
+		op := strings.TrimSpace(splits[0])
+		operand := splits[1]
+		builder.WriteString(fmt.Sprintf("%s %s", strings.ToUpper(op), operand))
+	} else if absolute(line) {
+		asm, err := absoluteInstruction(line.Assembly)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(asm)
+	} else {
+		pos := 0
+		for pos < len(line.Binary) {
+			if pos > 0 {
+				builder.WriteString("; ")
+			}
+			if len(line.Binary)-pos >= 4 {
+				builder.WriteString(fmt.Sprintf("LONG $0x%02x%02x%02x%02x",
+					line.Binary[pos+3], line.Binary[pos+2], line.Binary[pos+1], line.Binary[pos]))
+				pos += 4
+			} else if len(line.Binary)-pos >= 2 {
+				builder.WriteString(fmt.Sprintf("WORD $0x%02x%02x", line.Binary[pos+1], line.Binary[pos]))
+				pos += 2
+			} else {
+				builder.WriteString(fmt.Sprintf("BYTE $0x%02x", line.Binary[pos]))
+				pos += 1
+			}
+		}
+		builder.WriteString("\t// ")
+		builder.WriteString(line.Assembly)
+	}
+	builder.WriteString("\n")
+	return builder.String(), nil
+}
+
+func parseAssembly(path string, globals []internal.Global) (map[string][]internal.Line, map[string]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func(file *os.File) {
+		if err = file.Close(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}(file)
+
+	var (
+		stackSizes   = make(map[string]int)
+		functions    = make(map[string][]internal.Line)
+		functionName string
+		labelName    string
+		data         = internal.DataCollector{Globals: globals}
+	)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if ok, err := data.Collect(line, 4, binary.LittleEndian); err != nil {
+			return nil, nil, err
+		} else if ok {
+			continue
+		}
+		if attributeLine.MatchString(line) {
+			continue
+		} else if nameLine.MatchString(line) {
+			name, _, _ := strings.Cut(line, ":")
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			functionName = name
+			functions[functionName] = make([]internal.Line, 0)
+			labelName = ""
+		} else if labelLine.MatchString(line) {
+			labelName = strings.Split(line, ":")[0]
+			labelName = labelName[1:]
+			lines := functions[functionName]
+			if len(lines) > 0 && lines[len(lines)-1].Assembly == "" {
+				// If the last line is a label, append the label to the last line.
+				lines[len(lines)-1].Labels = append(lines[len(lines)-1].Labels, labelName)
+			} else {
+				functions[functionName] = append(functions[functionName], internal.Line{Labels: []string{labelName}})
+			}
+		} else if codeLine.MatchString(line) {
+			asm := sanitizeAsm(line)
+			if labelName == "" {
+				functions[functionName] = append(functions[functionName], internal.Line{Assembly: asm})
+			} else {
+				lines := functions[functionName]
+				if len(lines) == 0 {
+					functions[functionName] = append(functions[functionName], internal.Line{Labels: []string{labelName}})
+					lines = functions[functionName]
+				}
+				lines[len(lines)-1].Assembly = asm
+				labelName = ""
+			}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	dataSymbols = data.Symbols
+	return functions, stackSizes, nil
+}
+
+func sanitizeAsm(asm string) string {
+	asm, _, _ = strings.Cut(asm, "#")
+	return strings.TrimSpace(asm)
+}
+
+func parseObjectDump(dump string, functions map[string][]internal.Line) error {
+	var (
+		functionName string
+		lineNumber   int
+	)
+	for i, line := range strings.Split(dump, "\n") {
+		line = strings.TrimSpace(line)
+		if relocation, ok := internal.ParseRelocation(line); ok {
+			if lineNumber > 0 {
+				lines := functions[functionName]
+				lines[lineNumber-1].Relocations = append(lines[lineNumber-1].Relocations, relocation)
+			}
+		} else if symbolLine.MatchString(line) {
+			functionName = strings.Split(line, "<")[1]
+			functionName = strings.Split(functionName, ">")[0]
+			lineNumber = 0
+		} else if dataLine.MatchString(line) {
+			data := strings.Split(line, ":")[1]
+			data = strings.TrimSpace(data)
+			splits := strings.Split(data, " ")
+			var (
+				binary   strings.Builder
+				assembly string
+			)
+			for i, s := range splits {
+				if s == "" || unicode.IsSpace(rune(s[0])) {
+					assembly = strings.Join(splits[i:], " ")
+					assembly = strings.TrimSpace(assembly)
+					break
+				}
+				decoded, err := hex.DecodeString(s)
+				if err != nil {
+					return fmt.Errorf("%d: invalid 386 instruction bytes %q: %w", i, s, err)
+				}
+				binary.Write(decoded)
+			}
+
+			lines := functions[functionName]
+			if assembly == "" {
+				return fmt.Errorf("try to increase --insn-width of objdump")
+			} else if (strings.Contains(assembly, "nop") || assembly == "xchg   %ax,%ax") &&
+				(lineNumber >= len(lines) || !strings.HasPrefix(lines[lineNumber].Assembly, "nop")) {
+				// Padding before aligned functions and labels.
+				continue
+			}
+			if lineNumber >= len(lines) {
+				return fmt.Errorf("%d: unexpected objectdump line: %s", i, line)
+			}
+			lines[lineNumber].Binary = binary.String()
+			lineNumber++
+		}
+	}
+	return nil
+}
+
+// parameterSize returns the size of a parameter in the Go argument frame, where
+// 64-bit values are aligned to 4 bytes and long is int32.
+func parameterSize(param internal.Parameter) int {
+	if param.Pointer || param.Type == "long" {
+		return 4
+	}
+	return internal.SupportedTypes[param.Type]
+}
+
+// pushArgument returns the instructions that push a parameter into its cdecl
+// stack slot. The Go assembler adjusts the offsets of the arguments that follow
+// a push.
+func pushArgument(param internal.Parameter, offset int) string {
+	switch {
+	case param.Pointer || param.Type == "long":
+		return fmt.Sprintf("\tPUSHL %s+%d(FP)\n", param.Name, offset)
+	case param.Type == "_Bool":
+		return fmt.Sprintf("\tMOVBLZX %s+%d(FP), AX\n\tPUSHL AX\n", param.Name, offset)
+	case param.Type == "float":
+		return fmt.Sprintf("\tPUSHL %s+%d(FP)\n", param.Name, offset)
+	default:
+		return fmt.Sprintf("\tPUSHL %s_hi+%d(FP)\n\tPUSHL %s_lo+%d(FP)\n", param.Name, offset+4, param.Name, offset)
+	}
+}
+
+// generateArguments returns the instructions that pass the parameters of a
+// function following cdecl: every parameter is pushed from the last to the
+// first into 4-byte stack slots, with 64-bit values split into two slots, and a
+// slot is pushed in place of the return address. It also returns the size of
+// the Go argument frame and the number of pushed slots.
+func generateArguments(params []internal.Parameter) (string, int, int) {
+	var (
+		offsets []int
+		offset  int
+		slots   = 1
+	)
+	for _, param := range params {
+		sz := parameterSize(param)
+		alignment := min(sz, 4)
+		if offset%alignment != 0 {
+			offset += alignment - offset%alignment
+		}
+		offsets = append(offsets, offset)
+		offset += sz
+		if !param.Pointer && (param.Type == "int64_t" || param.Type == "double") {
+			slots += 2
+		} else {
+			slots++
+		}
+	}
+	if offset%4 != 0 {
+		offset += 4 - offset%4
+	}
+	var builder strings.Builder
+	for i := len(params) - 1; i >= 0; i-- {
+		builder.WriteString(pushArgument(params[i], offsets[i]))
+	}
+	builder.WriteString("\tPUSHL $0\n")
+	return builder.String(), offset, slots
+}
+
+func resultSize(typ string) int {
+	switch typ {
+	case "void":
+		return 0
+	case "_Bool":
+		return 1
+	case "float", "long":
+		return 4
+	default:
+		return 8
+	}
+}
+
+// storeResult returns the instructions that store the result of a function.
+// Floating point results are popped from the x87 stack.
+func storeResult(typ string, offset int) (string, error) {
+	switch typ {
+	case "void":
+		return "", nil
+	case "_Bool":
+		return fmt.Sprintf("\tMOVB AX, result+%d(FP)\n", offset), nil
+	case "long":
+		return fmt.Sprintf("\tMOVL AX, result+%d(FP)\n", offset), nil
+	case "int64_t":
+		return fmt.Sprintf("\tMOVL AX, result_lo+%d(FP)\n\tMOVL DX, result_hi+%d(FP)\n", offset, offset+4), nil
+	case "float":
+		return fmt.Sprintf("\tFMOVFP F0, result+%d(FP)\n", offset), nil
+	case "double":
+		return fmt.Sprintf("\tFMOVDP F0, result+%d(FP)\n", offset), nil
+	default:
+		return "", fmt.Errorf("unsupported return type: %v", typ)
+	}
+}
+
+func generateGoAssembly(buildTags string, header string, goAssemblyPath string, functions []internal.Function) error {
+	// generate code
+	var builder strings.Builder
+	builder.WriteString(buildTags)
+	builder.WriteString(header)
+	if err := internal.CheckDataAlignment(dataSymbols); err != nil {
+		return err
+	}
+	data, err := internal.GenerateDataSymbols(dataSymbols, binary.LittleEndian)
+	if err != nil {
+		return err
+	}
+	builder.WriteString(data)
+	locals, err := internal.LocalFunctions(functions, dataSymbols, callTarget, tailCallTarget)
+	if err != nil {
+		return err
+	}
+	if err = internal.CheckRelocations(functions, locals, relocated); err != nil {
+		return err
+	}
+	for _, function := range functions {
+		if function.Local {
+			continue
+		}
+		arguments, offset, slots := generateArguments(function.Parameters)
+		returnLabel := fmt.Sprintf("%s_return", function.Name)
+		builder.WriteString(fmt.Sprintf("\nTEXT ·%v(SB), $0-%d\n", function.Name, offset+resultSize(function.Type)))
+		builder.WriteString(arguments)
+		// Every return branches to a single epilogue, since the Go assembler
+		// tracks the pushes of the arguments in the order of instructions.
+		var tailCalls []string
+		for _, line := range function.Lines {
+			for _, label := range line.Labels {
+				builder.WriteString(label)
+				builder.WriteString(":\n")
+			}
+			if line.Assembly == "" {
+				continue
+			}
+			if op, callee, ok := tailCall(line.Assembly); ok {
+				builder.WriteString(fmt.Sprintf("\t%s %s_tail%d\n", strings.ToUpper(op), function.Name, len(tailCalls)))
+				tailCalls = append(tailCalls, callee)
+			} else if retLine.MatchString(line.Assembly) {
+				builder.WriteString(fmt.Sprintf("\tJMP %s\n", returnLabel))
+			} else {
+				code, err := generateLine(line)
+				if err != nil {
+					return fmt.Errorf("function %s: %w", function.Name, err)
+				}
+				builder.WriteString(code)
+			}
+		}
+		for i, callee := range tailCalls {
+			// The callee must return here to store the result, so the call
+			// replaces the slot of the return address with its own.
+			builder.WriteString(fmt.Sprintf("%s_tail%d:\n", function.Name, i))
+			builder.WriteString("\tPOPL CX\n")
+			builder.WriteString(fmt.Sprintf("\tCALL %s<>(SB)\n", callee))
+			builder.WriteString("\tPUSHL CX\n")
+			builder.WriteString(fmt.Sprintf("\tJMP %s\n", returnLabel))
+		}
+		builder.WriteString(returnLabel)
+		builder.WriteString(":\n")
+		for i := 0; i < slots; i++ {
+			builder.WriteString("\tPOPL CX\n")
+		}
+		result, err := storeResult(function.Type, offset)
+		if err != nil {
+			return err
+		}
+		builder.WriteString(result)
+		builder.WriteString("\tRET\n")
+	}
+	for _, function := range locals {
+		builder.WriteString(internal.LocalTextHeader(function.Name))
+		var tailCalls []string
+		for _, line := range function.Lines {
+			for _, label := range line.Labels {
+				builder.WriteString(label)
+				builder.WriteString(":\n")
+			}
+			if line.Assembly == "" {
+				continue
+			}
+			if op, callee, ok := tailCall(line.Assembly); ok && op == "jmp" {
+				builder.WriteString(fmt.Sprintf("\tJMP %s<>(SB)\n", callee))
+			} else if ok {
+				builder.WriteString(fmt.Sprintf("\t%s %s_tail%d\n", strings.ToUpper(op), function.Name, len(tailCalls)))
+				tailCalls = append(tailCalls, callee)
+			} else {
+				code, err := generateLine(line)
+				if err != nil {
+					return fmt.Errorf("function %s: %w", function.Name, err)
+				}
+				builder.WriteString(code)
+			}
+		}
+		for i, callee := range tailCalls {
+			builder.WriteString(fmt.Sprintf("%s_tail%d:\n", function.Name, i))
+			builder.WriteString(fmt.Sprintf("\tJMP %s<>(SB)\n", callee))
+		}
+	}
+
+	// write file
+	f, err := os.Create(goAssemblyPath)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		if err = f.Close(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}(f)
+	bytes, err := asmfmt.Format(strings.NewReader(builder.String()))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(bytes)
+	return err
+}
@@ -21,11 +21,14 @@ import (
 
 	"github.com/gorse-io/goat/internal"
 	_ "github.com/gorse-io/goat/internal/amd64"
+	_ "github.com/gorse-io/goat/internal/arm"
 	_ "github.com/gorse-io/goat/internal/arm64"
 	_ "github.com/gorse-io/goat/internal/loong64"
-	_ "github.com/gorse-io/goat/internal/ppc64le"
+	_ "github.com/gorse-io/goat/internal/mips64"
+	_ "github.com/gorse-io/goat/internal/ppc64"
 	_ "github.com/gorse-io/goat/internal/riscv64"
 	_ "github.com/gorse-io/goat/internal/s390x"
+	_ "github.com/gorse-io/goat/internal/x86"
 	"github.com/spf13/cobra"
 )
 
@@ -64,6 +67,7 @@ var command = &cobra.Command{
 
 		internal.SetVerbose(verbose)
 		file := internal.NewTranslateUnit(args[0], output, target, options...)
+		file.Exports, _ = cmd.PersistentFlags().GetStringSlice("export")
 		if err := file.Translate(); err != nil {
 			_, _ = fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
@@ -76,6 +80,7 @@ func init() {
 	command.PersistentFlags().StringP("target", "t", runtime.GOARCH, "target architecture, using Go GOARCH names")
 	command.PersistentFlags().StringSliceP("machine-option", "m", nil, "machine option for clang")
 	command.PersistentFlags().StringSliceP("extra-option", "e", nil, "extra option for clang")
+	command.PersistentFlags().StringSliceP("export", "x", nil, "C global to export as a Go package-level variable")
 	command.PersistentFlags().IntP("optimize-level", "O", 0, "optimization level for clang")
 	command.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "if set, increase verbosity level")
 }
@@ -0,0 +1,16 @@
+//go:build amd64 || arm64
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculate(t *testing.T) {
+	for op, expected := range []int64{18, 6, 72, 4, 14, 10, 0, 768, 0} {
+		assert.Equal(t, expected, calculate(int64(op), 12, 6))
+	}
+	assert.Equal(t, int64(0), calculate(-1, 12, 6))
+}
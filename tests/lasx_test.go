@@ -0,0 +1,12 @@
+//go:build loong64 && lasx
+
+package tests
+
+import "testing"
+
+func TestSquareAddLASX(t *testing.T) {
+	if !Supported() {
+		t.Skip("LASX is not supported")
+	}
+	testSquareAdd(t, square_add_lasx)
+}
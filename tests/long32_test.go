@@ -0,0 +1,6 @@
+//go:build 386 || arm
+
+package tests
+
+// long is the Go type of C long, which is 32-bit on 386 and arm.
+type long = int32
@@ -0,0 +1,6 @@
+//go:build !386 && !arm
+
+package tests
+
+// long is the Go type of C long.
+type long = int64
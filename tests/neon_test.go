@@ -0,0 +1,30 @@
+//go:build arm
+
+package tests
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSquareAddNEON(t *testing.T) {
+	testSquareAdd(t, square_add_neon)
+}
+
+func TestDotNEON(t *testing.T) {
+	// Both vectors start 8 bytes past a 16-byte boundary, which the alignment
+	// hints of the loads must accept.
+	buf := make([]float32, 84)
+	start := (24 - int(uintptr(unsafe.Pointer(&buf[0]))%16)) % 16 / 4
+	a := buf[start : start+37]
+	b := buf[start+40 : start+77]
+	var expected float32
+	for i := range a {
+		a[i] = float32(i)
+		b[i] = 2
+		expected += a[i] * b[i]
+	}
+	assert.Equal(t, expected, dot_neon(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), long(len(a))))
+}
@@ -0,0 +1,24 @@
+//go:build riscv64
+
+package tests
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSquareAddRVV(t *testing.T) {
+	testSquareAdd(t, square_add_rvv)
+}
+
+func TestSumRVV(t *testing.T) {
+	a := make([]float32, 1000)
+	var expected float32
+	for i := range a {
+		a[i] = float32(i)
+		expected += a[i]
+	}
+	assert.Equal(t, expected, sum_rvv(unsafe.Pointer(&a[0]), long(len(a))))
+}
@@ -0,0 +1,26 @@
+package tests
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSquareAdd checks a vector kernel that computes c[i] = a[i]*a[i] + b[i],
+// over a length that leaves a scalar tail, and that it stops at c[n].
+func testSquareAdd(t *testing.T, squareAdd func(a, b, c unsafe.Pointer, n long)) {
+	a := make([]float32, 37)
+	b := make([]float32, 37)
+	for i := range a {
+		a[i] = float32(i)
+		b[i] = float32(-i)
+	}
+	c := make([]float32, len(a)+1)
+	c[len(a)] = -1
+	squareAdd(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), unsafe.Pointer(&c[0]), long(len(a)))
+	for i := range a {
+		assert.Equal(t, a[i]*a[i]+b[i], c[i])
+	}
+	assert.Equal(t, float32(-1), c[len(a)])
+}
@@ -1,9 +1,20 @@
-#if defined(__aarch64__)
+#if defined(__ARM_NEON)
 #include <arm_neon.h>
 #elif defined(__riscv_vector)
 #include <riscv_vector.h>
 #elif defined(__s390x__)
 #include <vecintrin.h>
+#elif defined(__loongarch_asx)
+#include <lasxintrin.h>
+#endif
+#if defined(__ARM_FEATURE_SVE)
+#include <arm_sve.h>
+#endif
+
+#if defined(__clang__)
+#define MUSTTAIL __attribute__((musttail))
+#else
+#define MUSTTAIL
 #endif
 
 long add(long a, long b)
@@ -11,6 +22,11 @@ long add(long a, long b)
     return a + b;
 }
 
+long add_tail(long a, long b)
+{
+    MUSTTAIL return add(b, a);
+}
+
 float l2(const float *a, const float *b, long n)
 {
     float sum = 0;
@@ -49,6 +65,21 @@ float mul2(float a)
     return add_inline(a, a);
 }
 
+static long __attribute__((noinline)) square(long x)
+{
+    return x * x;
+}
+
+long sum_squares(long a, long b)
+{
+    return square(a) + square(b);
+}
+
+long add3(long a, long b, long c)
+{
+    return add(add(a, b), c);
+}
+
 _Bool _not(_Bool a)
 {
     return !a;
@@ -115,3 +146,296 @@ long base64_encode(const unsigned char *src, long n, char *dst)
 
     return j;
 }
+
+const long primes[8] = {2, 3, 5, 7, 11, 13, 17, 19};
+
+const double primes_inverse[4] = {0.5, 1.0 / 3, 0.2, 1.0 / 7};
+
+long prime(long i)
+{
+    return primes[i & 7];
+}
+
+double prime_inverse(long i)
+{
+    return primes_inverse[i & 3];
+}
+
+long greeting(long i)
+{
+    return "hello, goat"[i % 11];
+}
+
+// # starts a comment on some targets, but not in a string.
+const char hashtags[10] = "#goat \"#1\"";
+
+// So does @ on arm.
+const char address[9] = "user@host";
+
+long weekday_length(long i)
+{
+    static const char *const weekdays[7] = {"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"};
+    const char *day = weekdays[i % 7];
+    long n = 0;
+    while (day[n])
+        n++;
+    return n;
+}
+
+long fibonacci(long i)
+{
+    static const long table[12] = {0, 1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89};
+    return table[i % 12];
+}
+
+long aligned_address(long i)
+{
+    static const long long table[3] __attribute__((aligned(32))) = {3, 5, 7};
+    return (long)&table[i % 3];
+}
+
+float scale_shift(float x)
+{
+    return x * 1.7f + 0.3f;
+}
+
+void scale_shift_vec(float *x, long n)
+{
+    for (long i = 0; i < n; i++)
+    {
+        x[i] = x[i] * 1.7f + 0.3f;
+    }
+}
+
+long accumulate(long x)
+{
+    static long total = 100;
+    total += x;
+    return total;
+}
+
+static long histogram[16];
+
+long histogram_add(long i)
+{
+    return ++histogram[i & 15];
+}
+
+typedef struct
+{
+    long long values[128];
+} block;
+
+void block_copy(long long *dst, const long long *src)
+{
+    *(block *)dst = *(const block *)src;
+}
+
+void block_zero(long long *dst)
+{
+    *(block *)dst = (block){0};
+}
+
+float expf(float x);
+float logf(float x);
+float tanhf(float x);
+float erff(float x);
+
+float softplus(float x)
+{
+    return logf(1 + expf(x));
+}
+
+float gelu(float x)
+{
+    return 0.5f * x * (1 + erff(x * 0.70710678f));
+}
+
+float gelu_tanh(float x)
+{
+    return 0.5f * x * (1 + tanhf(0.79788456f * (x + 0.044715f * x * x * x)));
+}
+
+// fabsf is defined here, so only sqrtf is bundled.
+float fabsf(float x)
+{
+    return x < 0 ? -x : x;
+}
+
+float sqrtf(float x);
+
+float norm2(float x, float y)
+{
+    return sqrtf(fabsf(x) * fabsf(x) + y * y);
+}
+
+#if defined(__x86_64__) || defined(__aarch64__)
+long calculate(long op, long a, long b)
+{
+    switch (op)
+    {
+    case 0:
+        return a + b;
+    case 1:
+        return a - b;
+    case 2:
+        return a * b;
+    case 3:
+        return a & b;
+    case 4:
+        return a | b;
+    case 5:
+        return a ^ b;
+    case 7:
+        return a << b;
+    default:
+        return 0;
+    }
+}
+#endif
+
+// square_add_tail computes the elements from i on that vector kernels leave.
+static inline void square_add_tail(const float *a, const float *b, float *c, long i, long n)
+{
+    for (; i < n; i++)
+    {
+        c[i] = a[i] * a[i] + b[i];
+    }
+}
+
+#if defined(__ARM_NEON) && !defined(__aarch64__)
+void square_add_neon(const float *a, const float *b, float *c, long n)
+{
+    long i = 0;
+    for (; i + 4 <= n; i += 4)
+    {
+        float32x4_t va = vld1q_f32(a + i);
+        vst1q_f32(c + i, vmlaq_f32(vld1q_f32(b + i), va, va));
+    }
+    square_add_tail(a, b, c, i, n);
+}
+
+float dot_neon(const float *a, const float *b, long n)
+{
+    // Loads carry 16-byte alignment hints, which are lowered to the 8 bytes
+    // that Go guarantees.
+    a = __builtin_assume_aligned(a, 16);
+    b = __builtin_assume_aligned(b, 16);
+    float32x4_t sum = vdupq_n_f32(0);
+    long i = 0;
+    for (; i + 4 <= n; i += 4)
+    {
+        sum = vmlaq_f32(sum, vld1q_f32(a + i), vld1q_f32(b + i));
+    }
+    float32x2_t half = vadd_f32(vget_low_f32(sum), vget_high_f32(sum));
+    float dot = vget_lane_f32(vpadd_f32(half, half), 0);
+    for (; i < n; i++)
+    {
+        dot += a[i] * b[i];
+    }
+    return dot;
+}
+#endif
+
+#if defined(__riscv_vector)
+static inline vfloat32m1_t square_rvv(vfloat32m1_t x, size_t vl)
+{
+    return __riscv_vfmul_vv_f32m1(x, x, vl);
+}
+
+void square_add_rvv(const float *a, const float *b, float *c, long n)
+{
+    for (size_t vl; n > 0; n -= vl, a += vl, b += vl, c += vl)
+    {
+        vl = __riscv_vsetvl_e32m1(n);
+        vfloat32m1_t va = __riscv_vle32_v_f32m1(a, vl);
+        vfloat32m1_t vb = __riscv_vle32_v_f32m1(b, vl);
+        __riscv_vse32_v_f32m1(c, __riscv_vfadd_vv_f32m1(square_rvv(va, vl), vb, vl), vl);
+    }
+}
+
+float sum_rvv(const float *a, long n)
+{
+    // Groups of eight vector registers are reduced into one.
+    vfloat32m1_t sum = __riscv_vfmv_s_f_f32m1(0, 1);
+    for (size_t vl; n > 0; n -= vl, a += vl)
+    {
+        vl = __riscv_vsetvl_e32m8(n);
+        sum = __riscv_vfredusum_vs_f32m8_f32m1(__riscv_vle32_v_f32m8(a, vl), sum, vl);
+    }
+    return __riscv_vfmv_f_s_f32m1_f32(sum);
+}
+#endif
+
+#if defined(__VEC__) && __ARCH__ >= 12
+static inline __vector float square_vec(__vector float x)
+{
+    return x * x;
+}
+
+void square_add_vec(const float *a, const float *b, float *c, long n)
+{
+    long i = 0;
+    for (; i + 4 <= n; i += 4)
+    {
+        vec_xst(square_vec(vec_xl(0, a + i)) + vec_xl(0, b + i), 0, c + i);
+    }
+    square_add_tail(a, b, c, i, n);
+}
+
+__vector double madd_vec(__vector double a, __vector double b, __vector double c)
+{
+    return vec_madd(a, b, c);
+}
+
+__vector float scale_vec(__vector float a, double s1, double s2, double s3, double s4, float s5)
+{
+    return a * (float)(s1 + s2 + s3 + s4 + s5);
+}
+#endif
+
+#if defined(__loongarch_asx)
+void square_add_lasx(const float *a, const float *b, float *c, long n)
+{
+    long i = 0;
+    for (; i + 8 <= n; i += 8)
+    {
+        __m256 va = (__m256)__lasx_xvld(a + i, 0);
+        __m256 vb = (__m256)__lasx_xvld(b + i, 0);
+        __lasx_xvst((__m256i)__lasx_xvfadd_s(__lasx_xvfmul_s(va, va), vb), c + i, 0);
+    }
+    square_add_tail(a, b, c, i, n);
+}
+#endif
+
+#if defined(__ARM_FEATURE_SVE2)
+__attribute__((noinline)) static svfloat32_t square_sve(svbool_t pg, svfloat32_t x)
+{
+    return svmul_f32_x(pg, x, x);
+}
+
+void square_add_sve(const float *a, const float *b, float *c, long n)
+{
+    for (long i = 0; i < n; i += svcntw())
+    {
+        svbool_t pg = svwhilelt_b32(i, n);
+        svfloat32_t va = svld1_f32(pg, a + i);
+        svfloat32_t vb = svld1_f32(pg, b + i);
+        svst1_f32(pg, c + i, svadd_f32_x(pg, square_sve(pg, va), vb));
+    }
+}
+
+long count_matches_sve2(const int8_t *a, const int8_t *b, long n)
+{
+    long count = 0;
+    for (long i = 0; i < n; i += svcntb())
+    {
+        svbool_t pg = svwhilelt_b8(i, n);
+        svint8_t va = svld1_s8(pg, a + i);
+        svint8_t vb = svld1_s8(pg, b + i);
+        // MATCH compares each element with every element of the same 128-bit segment.
+        count += svcntp_b8(pg, svmatch_s8(pg, va, vb));
+    }
+    return count;
+}
+#endif
@@ -0,0 +1,41 @@
+//go:build arm64 && sve
+
+package tests
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSquareAddSVE(t *testing.T) {
+	if !Supported() {
+		t.Skip("SVE2 is not supported")
+	}
+	testSquareAdd(t, square_add_sve)
+}
+
+func TestCountMatchesSVE2(t *testing.T) {
+	if !Supported() {
+		t.Skip("SVE2 is not supported")
+	}
+	a := make([]int8, 77)
+	b := make([]int8, 77)
+	for i := range a {
+		a[i] = int8(i%7 + 1)
+		b[i] = int8(i%5+1) * 2
+	}
+	var expected long
+	for i := range a {
+		// Elements are matched within 16-byte segments.
+		segment := i / 16 * 16
+		for _, x := range b[segment:min(segment+16, len(b))] {
+			if a[i] == x {
+				expected++
+				break
+			}
+		}
+	}
+	assert.Equal(t, expected, count_matches_sve2(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), long(len(a))))
+}
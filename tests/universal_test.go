@@ -2,6 +2,8 @@ package tests
 
 import (
 	"encoding/base64"
+	"math"
+	"runtime"
 	"testing"
 	"unsafe"
 
@@ -9,16 +11,20 @@ import (
 )
 
 func TestAdd(t *testing.T) {
-	a := int64(1)
-	b := int64(2)
+	a := long(1)
+	b := long(2)
 	c := add(a, b)
 	assert.Equal(t, a+b, c)
 }
 
+func TestAddTail(t *testing.T) {
+	assert.Equal(t, long(3), add_tail(1, 2))
+}
+
 func TestL2(t *testing.T) {
 	a := []float32{1, 2, 3, 4}
 	b := []float32{5, 6, 7, 8}
-	c := l2(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), int64(len(a)))
+	c := l2(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), long(len(a)))
 	assert.Equal(t, float32(64), c)
 }
 
@@ -34,12 +40,20 @@ func TestMul2(t *testing.T) {
 	assert.Equal(t, float32(4), mul2(2))
 }
 
+func TestSumSquares(t *testing.T) {
+	assert.Equal(t, long(25), sum_squares(3, 4))
+}
+
+func TestAdd3(t *testing.T) {
+	assert.Equal(t, long(6), add3(1, 2, 3))
+}
+
 func TestNot(t *testing.T) {
 	assert.False(t, _not(true))
 }
 
 func TestSum(t *testing.T) {
-	assert.Equal(t, int64(55), sum(1, 2, 3, 4, 5, 6, 7, 8, 9, 10))
+	assert.Equal(t, long(55), sum(1, 2, 3, 4, 5, 6, 7, 8, 9, 10))
 }
 
 func TestMul(t *testing.T) {
@@ -69,8 +83,134 @@ func TestBase64Encode(t *testing.T) {
 		[]byte("hello, goat"),
 	} {
 		dst := make([]byte, base64.StdEncoding.EncodedLen(len(input)))
-		n := base64_encode(ptr(input), int64(len(input)), ptr(dst))
-		assert.Equal(t, int64(len(dst)), n)
+		n := base64_encode(ptr(input), long(len(input)), ptr(dst))
+		assert.Equal(t, long(len(dst)), n)
 		assert.Equal(t, base64.StdEncoding.EncodeToString(input), string(dst))
 	}
 }
+
+func TestBase64EncodeTable(t *testing.T) {
+	assert.Equal(t, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/", string(base64_encode_table[:]))
+}
+
+func TestPrime(t *testing.T) {
+	for i, p := range []long{2, 3, 5, 7, 11, 13, 17, 19} {
+		assert.Equal(t, p, prime(long(i)))
+	}
+}
+
+func TestPrimeInverse(t *testing.T) {
+	for i, p := range []float64{2, 3, 5, 7} {
+		assert.Equal(t, 1/p, prime_inverse(long(i)))
+	}
+}
+
+func TestGreeting(t *testing.T) {
+	for i, c := range []byte("hello, goat") {
+		assert.Equal(t, long(c), greeting(long(i)))
+	}
+}
+
+func TestHashtags(t *testing.T) {
+	assert.Equal(t, "#goat \"#1\"", string(hashtags[:]))
+}
+
+func TestAddress(t *testing.T) {
+	assert.Equal(t, "user@host", string(address[:]))
+}
+
+func TestWeekdayLength(t *testing.T) {
+	for i, day := range []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"} {
+		assert.Equal(t, long(len(day)), weekday_length(long(i)))
+	}
+}
+
+func TestFibonacci(t *testing.T) {
+	for i, f := range []long{0, 1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89} {
+		assert.Equal(t, f, fibonacci(long(i)))
+	}
+}
+
+func TestAlignedAddress(t *testing.T) {
+	if runtime.GOARCH == "arm" {
+		t.Skip("the Go linker aligns data to at most 8 bytes on arm")
+	}
+	assert.Zero(t, aligned_address(0)%32)
+	assert.Equal(t, aligned_address(0)+16, aligned_address(2))
+}
+
+func TestScaleShift(t *testing.T) {
+	assert.InDelta(t, float32(3.7), scale_shift(2), 1e-6)
+}
+
+func TestScaleShiftVec(t *testing.T) {
+	x := make([]float32, 100)
+	for i := range x {
+		x[i] = float32(i)
+	}
+	scale_shift_vec(unsafe.Pointer(&x[0]), long(len(x)))
+	for i := range x {
+		assert.InDelta(t, float32(i)*1.7+0.3, x[i], 1e-4)
+	}
+}
+
+func TestAccumulate(t *testing.T) {
+	total := accumulate(0)
+	assert.GreaterOrEqual(t, total, long(100))
+	assert.Equal(t, total+1, accumulate(1))
+	assert.Equal(t, total+3, accumulate(2))
+}
+
+func TestHistogramAdd(t *testing.T) {
+	count := histogram_add(3)
+	assert.Positive(t, count)
+	assert.Equal(t, count+1, histogram_add(19))
+	count = histogram_add(4)
+	assert.Equal(t, count+1, histogram_add(20))
+}
+
+func TestBlockCopy(t *testing.T) {
+	src := make([]int64, 128)
+	for i := range src {
+		src[i] = int64(i)
+	}
+	dst := make([]int64, 128)
+	block_copy(unsafe.Pointer(&dst[0]), unsafe.Pointer(&src[0]))
+	assert.Equal(t, src, dst)
+}
+
+func TestBlockZero(t *testing.T) {
+	dst := make([]int64, 128)
+	for i := range dst {
+		dst[i] = int64(i)
+	}
+	block_zero(unsafe.Pointer(&dst[0]))
+	assert.Equal(t, make([]int64, 128), dst)
+}
+
+func TestSoftplus(t *testing.T) {
+	for _, x := range []float32{-3, -0.5, 0, 0.5, 3} {
+		expected := math.Log(1 + math.Exp(float64(x)))
+		assert.InDelta(t, expected, softplus(x), 1e-6)
+	}
+}
+
+func TestGelu(t *testing.T) {
+	for _, x := range []float32{-3, -0.5, 0, 0.5, 3} {
+		expected := 0.5 * float64(x) * (1 + math.Erf(float64(x)/math.Sqrt2))
+		assert.InDelta(t, expected, gelu(x), 1e-6)
+	}
+}
+
+func TestGeluTanh(t *testing.T) {
+	for _, x := range []float32{-3, -0.5, 0, 0.5, 3} {
+		v := float64(x)
+		expected := 0.5 * v * (1 + math.Tanh(math.Sqrt(2/math.Pi)*(v+0.044715*v*v*v)))
+		assert.InDelta(t, expected, gelu_tanh(x), 1e-5)
+	}
+}
+
+func TestNorm2(t *testing.T) {
+	assert.Equal(t, float32(3), fabsf(-3))
+	assert.Equal(t, float32(5), norm2(-3, 4))
+}
@@ -0,0 +1,21 @@
+//go:build s390x
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSquareAddVec(t *testing.T) {
+	testSquareAdd(t, square_add_vec)
+}
+
+func TestMaddVec(t *testing.T) {
+	assert.Equal(t, [2]float64{7, 14}, madd_vec([2]float64{1, 2}, [2]float64{3, 4}, [2]float64{4, 6}))
+}
+
+func TestScaleVec(t *testing.T) {
+	assert.Equal(t, [4]float32{15, 30, 45, 60}, scale_vec([4]float32{1, 2, 3, 4}, 1, 2, 3, 4, 5))
+}